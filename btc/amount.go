@@ -0,0 +1,180 @@
+// Package btc holds bitcoin value types shared by the CLI and the POC programs.
+package btc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of bitcoin in satoshis.
+type Amount int64
+
+// Units an Amount can be parsed from and formatted in.
+const (
+	Satoshi  Amount = 1
+	Bit      Amount = 100 // 1 µBTC
+	MilliBTC Amount = 100_000
+	BTC      Amount = 100_000_000
+
+	// MaxAmount is the total supply of bitcoin.
+	MaxAmount = 21_000_000 * BTC
+)
+
+// units maps the accepted unit suffixes to their value.
+var units = map[string]Amount{
+	"btc":      BTC,
+	"mbtc":     MilliBTC,
+	"bit":      Bit,
+	"bits":     Bit,
+	"ubtc":     Bit,
+	"µbtc":     Bit,
+	"sat":      Satoshi,
+	"sats":     Satoshi,
+	"satoshi":  Satoshi,
+	"satoshis": Satoshi,
+}
+
+// decimals returns the number of fractional digits of unit, e.g. 8 for BTC.
+func decimals(unit Amount) int {
+	n := 0
+	for u := unit; u > 1; u /= 10 {
+		n++
+	}
+	return n
+}
+
+// ParseAmount parses a decimal number with an optional unit suffix
+// ("0.001", "0.001 BTC", "1.5mBTC", "250 bits", "546 sat"). Numbers without
+// a unit are in BTC. Precision finer than one satoshi is rejected.
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	num := strings.TrimRightFunc(s, func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	})
+	unit := BTC
+	if suffix := strings.ToLower(strings.TrimSpace(s[len(num):])); suffix != "" {
+		u, ok := units[suffix]
+		if !ok {
+			return 0, fmt.Errorf("invalid amount %q: unknown unit %q", s, suffix)
+		}
+		unit = u
+	}
+	a, err := parseDecimal(strings.TrimSpace(num), unit)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	return a, nil
+}
+
+// parseDecimal converts the decimal string s expressed in unit to satoshis
+// without going through floating point.
+func parseDecimal(s string, unit Amount) (Amount, error) {
+	neg := strings.HasPrefix(s, "-")
+	if neg || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, errors.New("missing number")
+	}
+	if strings.Trim(whole+frac, "0123456789") != "" {
+		return 0, errors.New("invalid number")
+	}
+
+	digits := decimals(unit)
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > digits {
+		return 0, errors.New("more precise than one satoshi")
+	}
+	frac += strings.Repeat("0", digits-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	var f int64
+	if frac != "" {
+		if f, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return 0, err
+		}
+	}
+	if w > int64(MaxAmount/unit) {
+		return 0, errors.New("exceeds the total supply")
+	}
+	a := Amount(w)*unit + Amount(f)
+	if a > MaxAmount {
+		return 0, errors.New("exceeds the total supply")
+	}
+	if neg {
+		a = -a
+	}
+	return a, nil
+}
+
+// FromBTC converts a floating point BTC value, rounding to the nearest satoshi.
+func FromBTC(f float64) (Amount, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid amount %v", f)
+	}
+	if math.Abs(f) > float64(MaxAmount/BTC) {
+		return 0, fmt.Errorf("invalid amount %v: exceeds the total supply", f)
+	}
+	return Amount(math.Round(f * float64(BTC))), nil
+}
+
+// ToBTC returns a as a floating point number of BTC.
+func (a Amount) ToBTC() float64 {
+	return float64(a) / float64(BTC)
+}
+
+// Format returns a as an exact decimal number of unit, without the unit
+// name, e.g. "0.00014872" for BTC.
+func (a Amount) Format(unit Amount) string {
+	sign := ""
+	if a < 0 {
+		sign, a = "-", -a
+	}
+	digits := decimals(unit)
+	whole := strconv.FormatInt(int64(a/unit), 10)
+	if digits == 0 {
+		return sign + whole
+	}
+	return fmt.Sprintf("%s%s.%0*d", sign, whole, digits, int64(a%unit))
+}
+
+// String formats a in BTC with all 8 decimals, e.g. "0.00014872 BTC".
+func (a Amount) String() string {
+	return a.Format(BTC) + " BTC"
+}
+
+// MarshalJSON encodes a as a BTC number, the way bitcoind does.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.Format(BTC)), nil
+}
+
+// UnmarshalJSON decodes a BTC number (or numeric string) as sent by bitcoind.
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "null" {
+		return nil
+	}
+	v, err := parseDecimal(s, BTC)
+	if err != nil && strings.ContainsAny(s, "eE") {
+		// Exponent notation such as 1e-05 is spelled out in decimals, so
+		// that it is held to the same precision.
+		if f, ferr := strconv.ParseFloat(s, 64); ferr == nil {
+			v, err = parseDecimal(strconv.FormatFloat(f, 'f', -1, 64), BTC)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid amount %s: %w", b, err)
+	}
+	*a = v
+	return nil
+}
@@ -0,0 +1,142 @@
+package btc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{"0.001", 100_000},
+		{"0.001 BTC", 100_000},
+		{"21000000", MaxAmount},
+		{"1.5mBTC", 150_000},
+		{"250 bits", 25_000},
+		{"1 bit", 100},
+		{"2 µBTC", 200},
+		{"2uBTC", 200},
+		{"546 sat", 546},
+		{"1 satoshi", 1},
+		{"  7 SATS ", 7},
+		{".5", 50_000_000},
+		{"5.", 500_000_000},
+		{"+1 sat", 1},
+		{"0.10000000000", 10_000_000}, // Trailing zeros do not add precision.
+		{"-0.5", -50_000_000},
+		{"-546 sat", -546},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseAmount(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseAmountErrors(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", "missing number"},
+		{"BTC", "missing number"},
+		{"1 doge", "unknown unit"},
+		{"0.000000001", "more precise than one satoshi"},
+		{"0.0001 bits", "more precise than one satoshi"},
+		{"1.5 sat", "more precise than one satoshi"},
+		{"21000000.00000001", "exceeds the total supply"},
+		{"2100000000000001 sat", "exceeds the total supply"},
+		{"99999999999999999999 sat", "out of range"}, // Beyond int64.
+		{"1.2.3", "invalid number"},
+		{"--1", "invalid number"},
+		{"-+1", "invalid number"},
+		{"1.-5", "invalid number"},
+	}
+	for _, tt := range tests {
+		if got, err := ParseAmount(tt.in); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseAmount(%q) = %d, %v, want an error containing %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		a    Amount
+		unit Amount
+		want string
+	}{
+		{14_872, BTC, "0.00014872"},
+		{MaxAmount, BTC, "21000000.00000000"},
+		{150_000, MilliBTC, "1.50000"},
+		{25_001, Bit, "250.01"},
+		{546, Satoshi, "546"},
+		{-1, BTC, "-0.00000001"},
+		{-150_000, MilliBTC, "-1.50000"},
+		{0, BTC, "0.00000000"},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Format(tt.unit); got != tt.want {
+			t.Errorf("Amount(%d).Format(%d) = %q, want %q", tt.a, tt.unit, got, tt.want)
+		}
+	}
+	if got := Amount(14_872).String(); got != "0.00014872 BTC" {
+		t.Errorf("String() = %q, want \"0.00014872 BTC\"", got)
+	}
+
+	// What Format writes, ParseAmount reads back.
+	names := map[Amount]string{BTC: "BTC", MilliBTC: "mBTC", Bit: "bits", Satoshi: "sat"}
+	for _, a := range []Amount{0, 1, 99, 100_001, BTC - 1, MaxAmount, -12_345_678} {
+		for unit, name := range names {
+			s := a.Format(unit) + " " + name
+			if got, err := ParseAmount(s); err != nil || got != a {
+				t.Errorf("ParseAmount(%q) = %d, %v, want %d", s, got, err, a)
+			}
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{`0.00014872`, 14_872},
+		{`"0.00014872"`, 14_872},
+		{`1`, BTC},
+		{`-0.5`, -50_000_000},
+		{`1e-05`, 1_000},
+		{`1.5E-7`, 15},
+		{`2.1e7`, MaxAmount},
+	}
+	for _, tt := range tests {
+		var a Amount
+		if err := json.Unmarshal([]byte(tt.in), &a); err != nil || a != tt.want {
+			t.Errorf("unmarshal %s = %d, %v, want %d", tt.in, a, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{`"ten"`, `1e300`, `0.000000001`, `1e-9`, `true`} {
+		var a Amount
+		if err := json.Unmarshal([]byte(in), &a); err == nil {
+			t.Errorf("unmarshal %s = %d, want an error", in, a)
+		}
+	}
+
+	// null leaves the amount as it is.
+	a := Amount(7)
+	if err := json.Unmarshal([]byte(`null`), &a); err != nil || a != 7 {
+		t.Errorf("unmarshal null = %d, %v, want 7 kept", a, err)
+	}
+
+	b, err := json.Marshal(struct{ Value Amount }{-14_872})
+	if err != nil || string(b) != `{"Value":-0.00014872}` {
+		t.Errorf("marshal = %s, %v", b, err)
+	}
+	var back struct{ Value Amount }
+	if err := json.Unmarshal(b, &back); err != nil || back.Value != -14_872 {
+		t.Errorf("unmarshal %s = %d, %v, want -14872", b, back.Value, err)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+func newCreateWalletCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "createwallet <walletname>",
+		Short: "Create a new wallet",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := a.wallet.CreateWallet(cmd.Context(), args[0]); err != nil {
+				return err
+			}
+			return a.out.print(output{
+				value: struct {
+					Name string `json:"name"`
+				}{args[0]},
+				text:  fmt.Sprintf("Wallet '%s' created.\n", args[0]),
+				quiet: []string{args[0]},
+			})
+		},
+	}
+}
+
+func newGetAddressesByAccountCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "getaddressesbyaccount <account>",
+		Short: "List all addresses of an account",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			accountName := args[0]
+			addresses, err := a.wallet.GetAddressesByAccount(cmd.Context(), accountName)
+			if err != nil {
+				return err
+			}
+			t := &table{header: []string{"ADDRESS"}}
+			for _, addr := range addresses {
+				t.rows = append(t.rows, []string{addr})
+			}
+			return a.out.print(output{
+				value: addresses,
+				text:  fmt.Sprintf("Addresses for account '%s':\n%s\n", accountName, strings.Join(addresses, ", ")),
+				quiet: addresses,
+				table: t,
+			})
+		},
+	}
+}
+
+func newGetNewAddressCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "getnewaddress [tag]",
+		Short: "Generate a new address for the wallet",
+		Args:  usageArgs(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tag := ""
+			if len(args) > 0 {
+				tag = args[0]
+			}
+			address, err := a.wallet.GetNewAddress(cmd.Context(), tag)
+			if err != nil {
+				return err
+			}
+			return a.out.print(output{
+				value: struct {
+					Address string `json:"address"`
+				}{address},
+				text:  fmt.Sprintf("New address: %s\n", address),
+				quiet: []string{address},
+			})
+		},
+	}
+}
+
+func newGetBalanceCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "getbalance [account] [confirmations]",
+		Short: "Show the wallet balance (of all addresses by default)",
+		Long: `Show the wallet balance (of all addresses by default).
+
+confirmations defaults to 1: only funds included in a block are counted.
+With 0, transactions still in the mempool are counted as well (those funds
+are not available for spending yet).`,
+		Args: usageArgs(cobra.MaximumNArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			accountName := ""
+			if len(args) > 0 {
+				accountName = args[0]
+			}
+			confirmations := 1
+			if len(args) > 1 {
+				n, err := strconv.Atoi(args[1])
+				if err != nil {
+					return usageError{fmt.Errorf("invalid confirmations %q", args[1])}
+				}
+				confirmations = n
+			}
+
+			balance, err := a.wallet.GetBalance(cmd.Context(), accountName, confirmations)
+			if err != nil {
+				return err
+			}
+			return a.out.print(output{
+				value: struct {
+					Account       string     `json:"account"`
+					Confirmations int        `json:"confirmations"`
+					Balance       btc.Amount `json:"balance"`
+				}{accountName, confirmations, balance},
+				text:  fmt.Sprintf("Balance: %s\n", balance),
+				quiet: []string{balance.Format(btc.BTC)},
+			})
+		},
+	}
+}
+
+func newSendCmd(a *app) *cobra.Command {
+	var unlockTimeout int
+	cmd := &cobra.Command{
+		Use:   "send <destination> [amount]",
+		Short: "Send BTC to an address (defaults to the 546 satoshi dust limit)",
+		Long: `Send BTC to an address (defaults to the 546 satoshi dust limit).
+
+The amount is in BTC unless a unit is given: "0.0001", "1.5 mBTC", "250 bits"
+and "10000 sat" are all accepted.`,
+		Args: usageArgs(cobra.RangeArgs(1, 2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dest := args[0]
+			if err := a.params.CheckAddress(dest); err != nil {
+				return usageError{err}
+			}
+			amount := 546 * btc.Satoshi // minimum amount allowed to send
+			if len(args) > 1 {
+				v, err := btc.ParseAmount(args[1])
+				if err != nil {
+					return usageError{err}
+				}
+				if v <= 0 {
+					return usageError{fmt.Errorf("amount must be positive, got %s", v)}
+				}
+				amount = v
+			}
+
+			a.out.progress("Sending %s to %s\n", amount, dest)
+
+			if err := a.wallet.WalletPassphrase(cmd.Context(), a.cfg.WalletPassphrase, unlockTimeout); err != nil {
+				return err
+			}
+			txid, err := a.wallet.SendToAddress(cmd.Context(), dest, amount)
+			if err != nil {
+				return err
+			}
+			return a.out.print(output{
+				value: struct {
+					TxID string `json:"txid"`
+				}{txid},
+				text:  fmt.Sprintf("Transaction sent! TXID: %s\n", txid),
+				quiet: []string{txid},
+			})
+		},
+	}
+	cmd.Flags().IntVar(&unlockTimeout, "unlock-timeout", 10, "seconds the wallet stays unlocked")
+	return cmd
+}
+
+func newGetRawTxCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "getrawtx <txid> [blockhash]",
+		Short: "Get a transaction from the node",
+		Long: `Get a transaction from the node.
+
+Without a block hash only mempool transactions (or all of them, if the node
+runs with -txindex) can be found.`,
+		Args:    usageArgs(cobra.RangeArgs(1, 2)),
+		PreRunE: a.requireNode,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			txid := args[0]
+			blockHash := ""
+			if len(args) > 1 {
+				blockHash = args[1]
+			}
+
+			a.out.progress("Getting details for transaction %s\n", txid)
+
+			txDetails, err := a.node.GetRawTransaction(cmd.Context(), txid, blockHash)
+			if err != nil {
+				if errors.Is(err, rpc.ErrTxNotFound) && blockHash == "" {
+					return errors.New("transaction not found in the mempool, run getrawtx with the blockhash to get the transaction details")
+				}
+				return err
+			}
+			return a.out.print(output{
+				value: txDetails,
+				text:  fmt.Sprintf("Transaction Details:\n%s\n", compactJSON(txDetails)),
+				quiet: []string{txDetails.TxID},
+			})
+		},
+	}
+}
+
+func newGetTxCmd(a *app) *cobra.Command {
+	var watchOnly bool
+	cmd := &cobra.Command{
+		Use:   "gettx <txid>",
+		Short: "Get a transaction from the wallet",
+		Long: `Get a transaction from the wallet.
+
+Only transactions included in a block and associated with the wallet are found.`,
+		Args: usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			txid := args[0]
+			a.out.progress("Getting details for transaction %s\n", txid)
+
+			txDetails, err := a.wallet.GetTransaction(cmd.Context(), txid, watchOnly)
+			if err != nil {
+				return err
+			}
+			return a.out.print(output{
+				value: txDetails,
+				text:  fmt.Sprintf("Transaction Details:\n%s\n", compactJSON(txDetails)),
+				quiet: []string{txDetails.TxID},
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&watchOnly, "watchonly", true, "include watch-only addresses")
+	return cmd
+}
+
+func newGetBlockCmd(a *app) *cobra.Command {
+	var showTxIDs bool
+	cmd := &cobra.Command{
+		Use:     "getblock <blockhash>",
+		Short:   "Get a block from the node",
+		Args:    usageArgs(cobra.ExactArgs(1)),
+		PreRunE: a.requireNode,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			blockHash := args[0]
+			block, err := a.node.GetBlock(cmd.Context(), blockHash)
+			if err != nil {
+				return err
+			}
+			if !showTxIDs {
+				block.Tx = []string{"<list of txIDs>"}
+			}
+			return a.out.print(output{
+				value: block,
+				text:  fmt.Sprintf("Block Details for block %s:\n%s\n", blockHash, compactJSON(block)),
+				quiet: []string{block.Hash},
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&showTxIDs, "txids", false, "include the ids of all transactions in the block")
+	return cmd
+}
+
+func newListUnspentCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "listunspent [address]",
+		Short: "List the wallet's unspent outputs",
+		Args:  usageArgs(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address := ""
+			if len(args) > 0 {
+				address = args[0]
+			}
+			unspent, err := a.wallet.ListUnspent(cmd.Context(), address)
+			if err != nil {
+				return err
+			}
+			t := &table{header: []string{"TXID", "VOUT", "ADDRESS", "AMOUNT", "CONFIRMATIONS"}}
+			quiet := make([]string, 0, len(unspent))
+			for _, u := range unspent {
+				t.rows = append(t.rows, []string{
+					u.TxID,
+					strconv.FormatUint(uint64(u.Vout), 10),
+					u.Address,
+					u.Amount.Format(btc.BTC),
+					strconv.FormatInt(u.Confirmations, 10),
+				})
+				quiet = append(quiet, fmt.Sprintf("%s:%d", u.TxID, u.Vout))
+			}
+			return a.out.print(output{
+				value: unspent,
+				text:  fmt.Sprintf("Unspent Transactions:\n%s\n", compactJSON(unspent)),
+				quiet: quiet,
+				table: t,
+			})
+		},
+	}
+}
+
+func newDumpPrivKeyCmd(a *app) *cobra.Command {
+	var unlockTimeout int
+	cmd := &cobra.Command{
+		Use:   "dumpprivkey <address>",
+		Short: "Reveal the private key of a wallet address",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := a.wallet.WalletPassphrase(cmd.Context(), a.cfg.WalletPassphrase, unlockTimeout); err != nil {
+				return err
+			}
+			key, err := a.wallet.DumpPrivKey(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			return a.out.print(output{
+				value: struct {
+					Address string `json:"address"`
+					PrivKey string `json:"privkey"`
+				}{args[0], key},
+				text:  fmt.Sprintf("Private key for address: %s\n", key),
+				quiet: []string{key},
+			})
+		},
+	}
+	cmd.Flags().IntVar(&unlockTimeout, "unlock-timeout", 10, "seconds the wallet stays unlocked")
+	return cmd
+}
@@ -0,0 +1,309 @@
+// Package config loads node and wallet connection settings shared by the
+// CLI and the POC programs.
+//
+// Settings are resolved from, in increasing order of precedence: built-in
+// defaults, a YAML file, BTC101_* environment variables and command line flags.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"bitcoin-playground/network"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Endpoint describes how to reach and authenticate against a JSON-RPC server.
+type Endpoint struct {
+	URL  string `yaml:"url"`
+	User string `yaml:"user"`
+	Pass string `yaml:"pass"`
+
+	// CookieFile is bitcoind's .cookie file. When set it takes precedence
+	// over User and Pass.
+	CookieFile string `yaml:"cookiefile"`
+
+	// CAFile pins the server certificate (e.g. btcwallet's rpc.cert) instead
+	// of relying on the system roots.
+	CAFile string `yaml:"cafile"`
+	// CertFile and KeyFile hold an optional client certificate.
+	CertFile string `yaml:"certfile"`
+	KeyFile  string `yaml:"keyfile"`
+	// Insecure disables server certificate verification. Testing only.
+	Insecure bool `yaml:"insecure"`
+}
+
+// Config holds the settings of all tools.
+type Config struct {
+	// Network selects the chain; see network.Names. It also provides the
+	// default ports of endpoints without a URL.
+	Network          string   `yaml:"network"`
+	Node             Endpoint `yaml:"node"`
+	Wallet           Endpoint `yaml:"wallet"`
+	WalletPassphrase string   `yaml:"walletpassphrase"`
+	// Watch lists the addresses and output descriptors poc-indexing starts
+	// watching.
+	Watch []string `yaml:"watch"`
+}
+
+// Default returns the settings used when nothing else is configured.
+// Endpoint URLs are left empty; Load derives them from the network.
+func Default() Config {
+	return Config{
+		Network: network.Default,
+		Node: Endpoint{
+			User: "admin",
+			Pass: "admin",
+		},
+		Wallet: Endpoint{
+			User:   "admin",
+			Pass:   "admin",
+			CAFile: btcwalletCert(),
+		},
+		WalletPassphrase: "admin",
+	}
+}
+
+// NetParams returns the parameters of the configured network.
+func (c *Config) NetParams() (*network.Params, error) {
+	return network.Lookup(c.Network)
+}
+
+// applyNetwork validates the network and points endpoints without a URL at
+// its default ports on localhost.
+func (c *Config) applyNetwork() error {
+	params, err := c.NetParams()
+	if err != nil {
+		return err
+	}
+	c.Network = params.Name
+	if c.Node.URL == "" {
+		c.Node.URL = fmt.Sprintf("http://127.0.0.1:%d/", params.NodePort)
+	}
+	if c.Wallet.URL == "" {
+		c.Wallet.URL = fmt.Sprintf("https://127.0.0.1:%d/", params.WalletPort)
+	}
+	return nil
+}
+
+// setting binds one configuration value to its environment variable and flag.
+// Exactly one of str and boolean is set.
+type setting struct {
+	flag, env, usage string
+	str              func(*Config) *string
+	boolean          func(*Config) *bool
+}
+
+// set parses v into the field of c bound to s.
+func (s setting) set(c *Config, v string) error {
+	if s.boolean == nil {
+		*s.str(c) = v
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s: %w", v, s.flag, err)
+	}
+	*s.boolean(c) = b
+	return nil
+}
+
+var settings = []setting{
+	{flag: "network", env: "BTC101_NETWORK", usage: "network: " + strings.Join(network.Names(), ", "), str: func(c *Config) *string { return &c.Network }},
+	{flag: "node-url", env: "BTC101_NODE_URL", usage: "node JSON-RPC URL", str: func(c *Config) *string { return &c.Node.URL }},
+	{flag: "node-user", env: "BTC101_NODE_USER", usage: "node RPC user", str: func(c *Config) *string { return &c.Node.User }},
+	{flag: "node-pass", env: "BTC101_NODE_PASS", usage: "node RPC password", str: func(c *Config) *string { return &c.Node.Pass }},
+	{flag: "node-cookie", env: "BTC101_NODE_COOKIE", usage: "path to bitcoind's .cookie file", str: func(c *Config) *string { return &c.Node.CookieFile }},
+	{flag: "node-cafile", env: "BTC101_NODE_CAFILE", usage: "PEM certificate(s) trusted for the node", str: func(c *Config) *string { return &c.Node.CAFile }},
+	{flag: "node-insecure", env: "BTC101_NODE_INSECURE", usage: "skip TLS verification of the node", boolean: func(c *Config) *bool { return &c.Node.Insecure }},
+	{flag: "wallet-url", env: "BTC101_WALLET_URL", usage: "wallet JSON-RPC URL", str: func(c *Config) *string { return &c.Wallet.URL }},
+	{flag: "wallet-user", env: "BTC101_WALLET_USER", usage: "wallet RPC user", str: func(c *Config) *string { return &c.Wallet.User }},
+	{flag: "wallet-pass", env: "BTC101_WALLET_PASS", usage: "wallet RPC password", str: func(c *Config) *string { return &c.Wallet.Pass }},
+	{flag: "wallet-cookie", env: "BTC101_WALLET_COOKIE", usage: "path to the wallet's .cookie file", str: func(c *Config) *string { return &c.Wallet.CookieFile }},
+	{flag: "wallet-cafile", env: "BTC101_WALLET_CAFILE", usage: "PEM certificate(s) trusted for the wallet, e.g. btcwallet's rpc.cert", str: func(c *Config) *string { return &c.Wallet.CAFile }},
+	{flag: "wallet-cert", env: "BTC101_WALLET_CERT", usage: "client certificate presented to the wallet", str: func(c *Config) *string { return &c.Wallet.CertFile }},
+	{flag: "wallet-key", env: "BTC101_WALLET_KEY", usage: "private key of the wallet client certificate", str: func(c *Config) *string { return &c.Wallet.KeyFile }},
+	{flag: "wallet-insecure", env: "BTC101_WALLET_INSECURE", usage: "skip TLS verification of the wallet (testing only)", boolean: func(c *Config) *bool { return &c.Wallet.Insecure }},
+	{flag: "wallet-passphrase", env: "BTC101_WALLET_PASSPHRASE", usage: "passphrase used to unlock the wallet", str: func(c *Config) *string { return &c.WalletPassphrase }},
+}
+
+// rawFlag keeps the raw command line value of a setting so it can be applied
+// after the file and environment layers.
+type rawFlag struct {
+	value string
+	set   bool
+}
+
+func (f *rawFlag) String() string { return f.value }
+
+// Type names the value for pflag's help output.
+func (f *rawFlag) Type() string { return "string" }
+
+func (f *rawFlag) Set(v string) error {
+	f.value, f.set = v, true
+	return nil
+}
+
+// rawBoolFlag is a rawFlag that may be given without a value.
+type rawBoolFlag struct{ rawFlag }
+
+func (f *rawBoolFlag) IsBoolFlag() bool { return true }
+func (f *rawBoolFlag) Type() string     { return "bool" }
+
+func (f *rawBoolFlag) String() string {
+	if !f.set {
+		return "false"
+	}
+	return f.value
+}
+
+// configEnv names the environment variable holding the config file path.
+const configEnv = "BTC101_CONFIG"
+
+// Flags is the command line layer of the configuration.
+type Flags struct {
+	path   string
+	values []*rawFlag
+}
+
+// RegisterFlags registers the configuration flags on fs. Call Flags.Load
+// once fs has been parsed.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{values: make([]*rawFlag, len(settings))}
+	fs.StringVar(&f.path, "config", "", "path to a YAML config file (env "+configEnv+")")
+	for i, s := range settings {
+		usage := s.usage + " (env " + s.env + ")"
+		if s.boolean != nil {
+			v := &rawBoolFlag{}
+			f.values[i] = &v.rawFlag
+			fs.Var(v, s.flag, usage)
+			continue
+		}
+		f.values[i] = &rawFlag{}
+		fs.Var(f.values[i], s.flag, usage)
+	}
+	return f
+}
+
+// Load registers the configuration flags on fs, parses args and resolves the
+// final configuration. Positional arguments remain available via fs.Args().
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	f := RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return f.Load()
+}
+
+// Load resolves the configuration from the defaults, the config file, the
+// environment and the flags that were given explicitly.
+func (f *Flags) Load() (*Config, error) {
+	cfg := Default()
+
+	path := f.path
+	if path == "" {
+		path = os.Getenv(configEnv)
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(&cfg, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	for i, s := range settings {
+		if f.values[i].set {
+			if err := s.set(&cfg, f.values[i].value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := cfg.applyNetwork(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadFile merges the YAML file at path into c.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Credentials returns the user and password for e, reading the cookie file
+// if one is configured.
+func (e Endpoint) Credentials() (string, string, error) {
+	if e.CookieFile == "" {
+		return e.User, e.Pass, nil
+	}
+	data, err := os.ReadFile(e.CookieFile)
+	if err != nil {
+		return "", "", fmt.Errorf("read cookie file: %w", err)
+	}
+	user, pass, ok := strings.Cut(strings.TrimSpace(string(data)), ":")
+	if !ok {
+		return "", "", fmt.Errorf("malformed cookie file %s", e.CookieFile)
+	}
+	return user, pass, nil
+}
+
+// Host returns the host:port part of the URL, as expected by rpcclient.
+func (e Endpoint) Host() (string, error) {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %w", e.URL, err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid url %q: missing host", e.URL)
+	}
+	return u.Host, nil
+}
+
+// UseTLS reports whether the endpoint is reached over https.
+func (e Endpoint) UseTLS() bool {
+	return strings.HasPrefix(strings.ToLower(e.URL), "https://")
+}
+
+// btcwalletCert returns the path of the certificate btcwallet generates in its
+// default data directory, or "" if there is none.
+func btcwalletCert() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	var dir string
+	switch runtime.GOOS {
+	case "darwin":
+		dir = filepath.Join(home, "Library", "Application Support", "Btcwallet")
+	case "windows":
+		dir = filepath.Join(os.Getenv("LOCALAPPDATA"), "Btcwallet")
+	default:
+		dir = filepath.Join(home, ".btcwallet")
+	}
+	cert := filepath.Join(dir, "rpc.cert")
+	if _, err := os.Stat(cert); err != nil {
+		return ""
+	}
+	return cert
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bitcoin-playground/network"
+)
+
+// clearEnv unsets the configuration variables for the duration of the test
+// and points the home directory to an empty one.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	names := []string{configEnv}
+	for _, s := range settings {
+		names = append(names, s.env)
+	}
+	for _, name := range names {
+		t.Setenv(name, "") // Restores the variable afterwards.
+		os.Unsetenv(name)
+	}
+}
+
+// writeFile writes data to name in a temporary directory and returns its path.
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// load runs Load with a fresh flag set.
+func load(args ...string) (*Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args)
+}
+
+func TestLoadDefaults(t *testing.T) {
+	clearEnv(t)
+	cfg, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := network.Lookup(network.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.Network = params.Name
+	want.Node.URL = fmt.Sprintf("http://127.0.0.1:%d/", params.NodePort)
+	want.Wallet.URL = fmt.Sprintf("https://127.0.0.1:%d/", params.WalletPort)
+	if !reflect.DeepEqual(*cfg, want) {
+		t.Errorf("Load() = %+v, want %+v", *cfg, want)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "config.yaml", `
+network: testnet4
+node:
+  url: http://node.example:18332/
+  user: file
+  pass: file
+wallet:
+  user: file
+  pass: file
+walletpassphrase: file
+watch:
+  - tb1qexample
+`)
+	t.Setenv("BTC101_NETWORK", "signet")
+	t.Setenv("BTC101_NODE_USER", "env")
+	t.Setenv("BTC101_NODE_PASS", "env")
+	t.Setenv("BTC101_WALLET_USER", "env")
+
+	cfg, err := load("-config", path, "-node-user", "flag", "-node-insecure", "-wallet-passphrase", "flag", "rest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"network (env over file)", cfg.Network, "signet"},
+		{"node url (file)", cfg.Node.URL, "http://node.example:18332/"},
+		{"node user (flag over env)", cfg.Node.User, "flag"},
+		{"node pass (env over file)", cfg.Node.Pass, "env"},
+		{"node insecure (flag)", cfg.Node.Insecure, true},
+		{"wallet url (default of the network)", cfg.Wallet.URL, "https://127.0.0.1:38331/"},
+		{"wallet user (env over file)", cfg.Wallet.User, "env"},
+		{"wallet pass (file over default)", cfg.Wallet.Pass, "file"},
+		{"wallet passphrase (flag over file)", cfg.WalletPassphrase, "flag"},
+		{"watch (file)", cfg.Watch, []string{"tb1qexample"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// BTC101_CONFIG names the file when -config does not.
+	t.Setenv(configEnv, path)
+	if cfg, err = load("-node-user", "flag"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Node.URL != "http://node.example:18332/" || cfg.Node.User != "flag" {
+		t.Errorf("with %s: node %+v", configEnv, cfg.Node)
+	}
+	// An explicit false flag overrides a true setting.
+	t.Setenv("BTC101_NODE_INSECURE", "true")
+	if cfg, err = load("-node-insecure=false"); err != nil || cfg.Node.Insecure {
+		t.Errorf("-node-insecure=false over env: %+v, %v", cfg, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"missing file", nil, []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, "read config"},
+		{"invalid YAML", nil, []string{"-config", writeFile(t, "bad.yaml", "node: [")}, "parse config"},
+		{"unknown network", map[string]string{"BTC101_NETWORK": "moonnet"}, nil, "moonnet"},
+		{"invalid boolean variable", map[string]string{"BTC101_NODE_INSECURE": "maybe"}, nil, "BTC101_NODE_INSECURE"},
+		{"invalid boolean flag", nil, []string{"-wallet-insecure=maybe"}, "wallet-insecure"},
+		{"unknown flag", nil, []string{"-node-port", "1"}, "node-port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, err := load(tt.args...); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	clearEnv(t)
+	cookie := writeFile(t, ".cookie", "__cookie__:0123abcd\n")
+	cfg, err := load("-node-user", "user", "-node-pass", "pass", "-node-cookie", cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cookie takes precedence over the user and password.
+	if user, pass, err := cfg.Node.Credentials(); err != nil || user != "__cookie__" || pass != "0123abcd" {
+		t.Errorf("node credentials %q, %q, %v, want those of the cookie", user, pass, err)
+	}
+	if user, pass, err := cfg.Wallet.Credentials(); err != nil || user != "admin" || pass != "admin" {
+		t.Errorf("wallet credentials %q, %q, %v, want the defaults", user, pass, err)
+	}
+
+	for name, path := range map[string]string{
+		"malformed": writeFile(t, "bad.cookie", "no separator"),
+		"missing":   filepath.Join(t.TempDir(), ".cookie"),
+	} {
+		if _, _, err := (Endpoint{CookieFile: path}).Credentials(); err == nil {
+			t.Errorf("%s cookie file: Credentials succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/network"
+	"bitcoin-playground/rpctest"
+)
+
+// newTestNode starts a regtest stand-in node with a funded wallet address
+// and returns it with that address.
+func newTestNode(t *testing.T) (*rpctest.Server, string) {
+	t.Helper()
+	chain := rpctest.NewChain(&network.Regtest)
+	addr := chain.NewAddress("")
+	if _, err := chain.Fund(addr, 100_000*btc.Satoshi); err != nil {
+		t.Fatal(err)
+	}
+	chain.Mine(1)
+	srv := rpctest.NewServer(chain, "admin", "admin")
+	t.Cleanup(srv.Close)
+	return srv, addr
+}
+
+// runCLI runs the CLI with args against srv and returns its exit code and
+// what it wrote to stdout and stderr. The environment and home directory
+// are cleared so that only args configure it.
+func runCLI(t *testing.T, srv *rpctest.Server, args ...string) (int, string, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, "BTC101_") {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+	args = append([]string{"--network", "regtest", "--node-url", srv.URL, "--wallet-url", srv.URL}, args...)
+
+	stdout, stderr := capture(t, &os.Stdout), capture(t, &os.Stderr)
+	code := run(args)
+	return code, stdout(), stderr()
+}
+
+// capture redirects *f to a pipe until the returned function is called,
+// which restores it and returns what was written.
+func capture(t *testing.T, f **os.File) func() string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := *f
+	*f = w
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	return func() string {
+		*f = orig
+		w.Close()
+		return <-done
+	}
+}
+
+func TestCLIGetBalance(t *testing.T) {
+	srv, _ := newTestNode(t)
+	code, stdout, stderr := runCLI(t, srv, "getbalance", "-o", "json")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	var got struct {
+		Confirmations int        `json:"confirmations"`
+		Balance       btc.Amount `json:"balance"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatalf("decode %q: %v", stdout, err)
+	}
+	if got.Balance != 100_000*btc.Satoshi || got.Confirmations != 1 {
+		t.Errorf("getbalance = %+v, want 0.001 BTC with 1 confirmation", got)
+	}
+}
+
+func TestCLISend(t *testing.T) {
+	srv, _ := newTestNode(t)
+	dest := srv.Chain.NewAddress("other")
+	code, stdout, stderr := runCLI(t, srv, "send", dest, "10000 sat", "-q")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	txid := strings.TrimSpace(stdout)
+	mempool := srv.Chain.Mempool()
+	if len(mempool) != 1 || mempool[0].TxID() != txid {
+		t.Fatalf("send printed %q, mempool holds %d transactions", txid, len(mempool))
+	}
+	paid := false
+	for _, out := range mempool[0].Outputs {
+		script, _ := rpctest.AddressScript(&network.Regtest, dest)
+		paid = paid || (string(out.Script) == string(script) && out.Value == 10_000*btc.Satoshi)
+	}
+	if !paid {
+		t.Errorf("transaction %s does not pay 10000 sat to %s", txid, dest)
+	}
+}
+
+func TestCLISendWrongPassphrase(t *testing.T) {
+	srv, _ := newTestNode(t)
+	code, _, stderr := runCLI(t, srv, "--wallet-passphrase", "wrong", "send", srv.Chain.NewAddress(""))
+	if code != exitError || !strings.Contains(stderr, "passphrase") {
+		t.Errorf("exit %d, stderr %q; want a passphrase error", code, stderr)
+	}
+	if len(srv.Chain.Mempool()) != 0 {
+		t.Error("a transaction was sent with the wrong passphrase")
+	}
+}
+
+func TestCLIGetRawTx(t *testing.T) {
+	srv, addr := newTestNode(t)
+	block := srv.Chain.Tip()
+	txid := block.Txs[1].TxID()
+
+	code, _, stderr := runCLI(t, srv, "getrawtx", txid)
+	if code != exitError || !strings.Contains(stderr, "not found in the mempool") {
+		t.Errorf("without block hash: exit %d, stderr %q", code, stderr)
+	}
+
+	code, stdout, stderr := runCLI(t, srv, "getrawtx", txid, block.Hash(), "-o", "json")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	var tx struct {
+		TxID      string `json:"txid"`
+		BlockHash string `json:"blockhash"`
+		Vin       []struct {
+			TxID string  `json:"txid"`
+			Vout *uint32 `json:"vout"`
+		} `json:"vin"`
+		Vout []struct {
+			ScriptPubKey struct {
+				Address string `json:"address"`
+			} `json:"scriptPubKey"`
+		} `json:"vout"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &tx); err != nil {
+		t.Fatalf("decode %q: %v", stdout, err)
+	}
+	if tx.TxID != txid || tx.BlockHash != block.Hash() || tx.Vout[0].ScriptPubKey.Address != addr {
+		t.Errorf("getrawtx = %+v, want %s in %s paying %s", tx, txid, block.Hash(), addr)
+	}
+	// The payment spends output 0 of a coinbase, whose index is still
+	// reported.
+	if len(tx.Vin) == 0 || tx.Vin[0].Vout == nil || *tx.Vin[0].Vout != 0 {
+		t.Errorf("getrawtx inputs %s, want one spending vout 0", stdout)
+	}
+}
+
+func TestCLIGetBlock(t *testing.T) {
+	srv, _ := newTestNode(t)
+	hash := srv.Chain.Tip().Hash()
+	code, stdout, stderr := runCLI(t, srv, "getblock", hash, "-q")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if strings.TrimSpace(stdout) != hash {
+		t.Errorf("getblock -q = %q, want %s", stdout, hash)
+	}
+}
+
+func TestCLIListUnspent(t *testing.T) {
+	srv, addr := newTestNode(t)
+	code, stdout, stderr := runCLI(t, srv, "listunspent", addr, "-o", "table")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "TXID") || !strings.Contains(lines[1], addr) || !strings.Contains(lines[1], "0.00100000") {
+		t.Errorf("listunspent table:\n%s", stdout)
+	}
+}
+
+func TestCLINetworkMismatch(t *testing.T) {
+	srv, _ := newTestNode(t)
+	code, _, stderr := runCLI(t, srv, "getblock", srv.Chain.Tip().Hash(), "--network", "testnet4")
+	if code != exitError || !strings.Contains(stderr, "regtest") {
+		t.Errorf("exit %d, stderr %q; want a network mismatch", code, stderr)
+	}
+}
+
+func TestCLIWrongCredentials(t *testing.T) {
+	srv, _ := newTestNode(t)
+	code, _, stderr := runCLI(t, srv, "getbalance", "--wallet-pass", "wrong")
+	if code != exitError || !strings.Contains(stderr, "401") {
+		t.Errorf("exit %d, stderr %q; want an authentication error", code, stderr)
+	}
+}
+
+func TestCLIUsageErrors(t *testing.T) {
+	srv, _ := newTestNode(t)
+	for name, args := range map[string][]string{
+		"unknown command":       {"getbalanse"},
+		"unknown flag":          {"getbalance", "--nope"},
+		"invalid output format": {"getbalance", "-o", "xml"},
+		"invalid confirmations": {"getbalance", "", "many"},
+		"too many arguments":    {"gettx", "a", "b"},
+		"address of a network":  {"send", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"},
+		"zero amount":           {"send", srv.Chain.NewAddress(""), "0"},
+		"unknown network":       {"getbalance", "--network", "moonnet"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			code, _, stderr := runCLI(t, srv, args...)
+			if code != exitUsage || !strings.Contains(stderr, "--help") {
+				t.Errorf("exit %d, stderr %q; want a usage error", code, stderr)
+			}
+		})
+	}
+}
+
+func TestCLIHelpWithoutConfig(t *testing.T) {
+	srv, _ := newTestNode(t)
+	broken := filepath.Join(t.TempDir(), "broken.yaml")
+	if err := os.WriteFile(broken, []byte("node: ["), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for name, args := range map[string][]string{
+		"completion": {"completion", "bash"},
+		"help":       {"help", "getbalance"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			code, stdout, stderr := runCLI(t, srv, append(args, "--config", broken)...)
+			if code != exitOK || stdout == "" {
+				t.Errorf("exit %d, stderr %q; want %s to ignore the configuration", code, stderr, name)
+			}
+		})
+	}
+	// Other commands still report it.
+	if code, _, stderr := runCLI(t, srv, "getbalance", "--config", broken); code != exitUsage || !strings.Contains(stderr, "parse config") {
+		t.Errorf("getbalance: exit %d, stderr %q; want a usage error", code, stderr)
+	}
+}
@@ -0,0 +1,68 @@
+// Package btcdnet connects the networks of package network to btcd: their
+// chain parameters and rpcclient connections to their nodes. It is kept apart
+// from package network so that the CLI does not depend on btcd.
+package btcdnet
+
+import (
+	"fmt"
+	"os"
+
+	"bitcoin-playground/config"
+	"bitcoin-playground/network"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+// ChainParams maps a network to its btcd parameters.
+func ChainParams(p *network.Params) *chaincfg.Params {
+	switch p {
+	case &network.Mainnet:
+		return &chaincfg.MainNetParams
+	case &network.Testnet3:
+		return &chaincfg.TestNet3Params
+	case &network.Signet:
+		return &chaincfg.SigNetParams
+	case &network.Regtest:
+		return &chaincfg.RegressionNetParams
+	default:
+		return &chaincfg.TestNet4Params
+	}
+}
+
+// Connect returns an rpcclient client of the node at node, in HTTP POST mode.
+func Connect(node config.Endpoint) (*rpcclient.Client, error) {
+	host, err := node.Host()
+	if err != nil {
+		return nil, err
+	}
+	user, pass, err := node.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	connCfg := &rpcclient.ConnConfig{
+		Host:         host,
+		User:         user,
+		Pass:         pass,
+		HTTPPostMode: true,
+		DisableTLS:   !node.UseTLS(),
+	}
+	// rpcclient pins the given PEM certificates; client certificates and
+	// disabling verification are not supported by it.
+	if node.CAFile != "" {
+		connCfg.Certificates, err = os.ReadFile(node.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+	}
+	return rpcclient.New(connCfg, nil)
+}
+
+// CheckNetwork refuses to continue if the node is on another chain than p.
+func CheckNetwork(client *rpcclient.Client, p *network.Params) error {
+	info, err := client.GetBlockChainInfo()
+	if err != nil {
+		return fmt.Errorf("getblockchaininfo: %w", err)
+	}
+	return p.CheckChain(info.Chain)
+}
@@ -0,0 +1,87 @@
+// Package network describes the bitcoin networks the tools can operate on.
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Params holds the per-network settings that do not depend on btcd.
+type Params struct {
+	// Name is the value accepted by --network.
+	Name string
+	// Chain is the "chain" field reported by bitcoind's getblockchaininfo.
+	Chain string
+	// NodePort is bitcoind's default RPC port.
+	NodePort int
+	// WalletPort is the default btcwallet RPC port. This repository runs
+	// btcwallet one port below bitcoind, e.g. 48331 next to 48332.
+	WalletPort int
+	// Bech32HRP is the human readable part of SegWit addresses.
+	Bech32HRP string
+	// Base58Prefixes are the leading characters of P2PKH and P2SH addresses.
+	Base58Prefixes string
+	// PubKeyHashAddrID and ScriptHashAddrID are the version bytes of
+	// base58 P2PKH and P2SH addresses.
+	PubKeyHashAddrID byte
+	ScriptHashAddrID byte
+}
+
+// Default is the network used when none is configured.
+const Default = "testnet4"
+
+var (
+	Mainnet  = Params{Name: "mainnet", Chain: "main", NodePort: 8332, WalletPort: 8331, Bech32HRP: "bc", Base58Prefixes: "13", PubKeyHashAddrID: 0x00, ScriptHashAddrID: 0x05}
+	Testnet3 = Params{Name: "testnet3", Chain: "test", NodePort: 18332, WalletPort: 18331, Bech32HRP: "tb", Base58Prefixes: "mn2", PubKeyHashAddrID: 0x6f, ScriptHashAddrID: 0xc4}
+	Testnet4 = Params{Name: "testnet4", Chain: "testnet4", NodePort: 48332, WalletPort: 48331, Bech32HRP: "tb", Base58Prefixes: "mn2", PubKeyHashAddrID: 0x6f, ScriptHashAddrID: 0xc4}
+	Signet   = Params{Name: "signet", Chain: "signet", NodePort: 38332, WalletPort: 38331, Bech32HRP: "tb", Base58Prefixes: "mn2", PubKeyHashAddrID: 0x6f, ScriptHashAddrID: 0xc4}
+	Regtest  = Params{Name: "regtest", Chain: "regtest", NodePort: 18443, WalletPort: 18442, Bech32HRP: "bcrt", Base58Prefixes: "mn2", PubKeyHashAddrID: 0x6f, ScriptHashAddrID: 0xc4}
+)
+
+var byName = map[string]*Params{
+	Mainnet.Name:  &Mainnet,
+	"main":        &Mainnet,
+	Testnet3.Name: &Testnet3,
+	"testnet":     &Testnet3,
+	Testnet4.Name: &Testnet4,
+	Signet.Name:   &Signet,
+	Regtest.Name:  &Regtest,
+}
+
+// Names returns the canonical network names.
+func Names() []string {
+	names := []string{Mainnet.Name, Testnet3.Name, Testnet4.Name, Signet.Name, Regtest.Name}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the parameters of the named network.
+func Lookup(name string) (*Params, error) {
+	p, ok := byName[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q, must be one of %s", name, strings.Join(Names(), ", "))
+	}
+	return p, nil
+}
+
+// CheckChain returns an error unless chain, as reported by getblockchaininfo,
+// belongs to p.
+func (p *Params) CheckChain(chain string) error {
+	if chain != p.Chain {
+		return fmt.Errorf("node is on chain %q but network %s (chain %q) is selected", chain, p.Name, p.Chain)
+	}
+	return nil
+}
+
+// CheckAddress performs a cheap prefix check that catches addresses of other
+// networks. It does not validate the checksum.
+func (p *Params) CheckAddress(addr string) error {
+	if strings.HasPrefix(strings.ToLower(addr), p.Bech32HRP+"1") {
+		return nil
+	}
+	if addr != "" && strings.ContainsRune(p.Base58Prefixes, rune(addr[0])) {
+		return nil
+	}
+	return fmt.Errorf("address %s is not a %s address", addr, p.Name)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats selectable with --output.
+const (
+	formatText        = "text"
+	formatJSON        = "json"
+	formatJSONCompact = "json-compact"
+	formatTable       = "table"
+)
+
+var outputFormats = []string{formatText, formatJSON, formatJSONCompact, formatTable}
+
+// output describes a command result in every supported format.
+type output struct {
+	// value is encoded by the JSON formats, and by the table format when
+	// table is nil.
+	value interface{}
+	// text is the human readable form.
+	text string
+	// quiet holds the key values printed by --quiet, one per line.
+	quiet []string
+	// table is the tabular form of list results.
+	table *table
+}
+
+// table is a list of rows printed in aligned columns.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// printer writes outputs in the selected format.
+type printer struct {
+	w      io.Writer
+	format string
+	quiet  bool
+}
+
+// validate reports unknown formats as usage errors.
+func (p *printer) validate() error {
+	for _, f := range outputFormats {
+		if p.format == f {
+			return nil
+		}
+	}
+	return usageError{fmt.Errorf("invalid output format %q, must be one of %s", p.format, strings.Join(outputFormats, ", "))}
+}
+
+func (p *printer) print(o output) error {
+	if p.quiet {
+		for _, line := range o.quiet {
+			fmt.Fprintln(p.w, line)
+		}
+		return nil
+	}
+
+	switch p.format {
+	case formatJSON:
+		return encodeJSON(p.w, o.value, "  ")
+	case formatJSONCompact:
+		return encodeJSON(p.w, o.value, "")
+	case formatTable:
+		t := o.table
+		if t == nil {
+			var err error
+			if t, err = fieldTable(o.value); err != nil {
+				return err
+			}
+		}
+		return t.write(p.w)
+	default:
+		_, err := io.WriteString(p.w, o.text)
+		return err
+	}
+}
+
+// progress prints status messages that only belong in the human readable output.
+func (p *printer) progress(format string, args ...interface{}) {
+	if p.format == formatText && !p.quiet {
+		fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+func (t *table) write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// fieldTable renders a JSON object as FIELD/VALUE rows in field order.
+// Nested values are printed as compact JSON.
+func fieldTable(v interface{}) (*table, error) {
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, v, ""); err != nil {
+		return nil, err
+	}
+	b := bytes.TrimSpace(buf.Bytes())
+	t := &table{header: []string{"FIELD", "VALUE"}}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		// Not an object: a single value.
+		t.rows = append(t.rows, []string{"value", strings.Trim(string(b), `"`)})
+		return t, nil
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		t.rows = append(t.rows, []string{key.(string), strings.Trim(string(raw), `"`)})
+	}
+	return t, nil
+}
+
+// encodeJSON writes v followed by a newline, without escaping <, > and &.
+func encodeJSON(w io.Writer, v interface{}, indent string) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	return enc.Encode(v)
+}
+
+// compactJSON encodes v on a single line for the text format.
+func compactJSON(v interface{}) string {
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, v, ""); err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
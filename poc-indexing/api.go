@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/rpc"
+)
+
+// healthTimeout bounds the node query of /health.
+const healthTimeout = 5 * time.Second
+
+// apiServer answers queries about the cache over HTTP with JSON.
+type apiServer struct {
+	cache *Cache
+	node  *rpc.Client // checked by /health
+}
+
+// startAPI serves the HTTP API on addr (host:port) until the returned server
+// is shut down.
+func startAPI(addr string, cache *Cache, node *rpc.Client) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &apiServer{cache: cache, node: node}
+	srv := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("Warning: HTTP API stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("HTTP API listening on http://%s\n", ln.Addr())
+	return srv, nil
+}
+
+// handler routes the API endpoints.
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /utxos", s.utxos)
+	mux.HandleFunc("GET /balance/{address}", s.balance)
+	mux.HandleFunc("GET /tx/{txid}", s.transaction)
+	mux.HandleFunc("GET /height", s.height)
+	mux.HandleFunc("GET /health", s.health)
+	return mux
+}
+
+// utxos lists the UTXOs of the address query parameter, or of all watched
+// addresses without it, with their confirmation state.
+func (s *apiServer) utxos(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address != "" && !s.cache.isWatched(address) {
+		writeError(w, http.StatusNotFound, "address "+address+" is not watched")
+		return
+	}
+	utxos := s.cache.addressUTXOs(address)
+	if utxos == nil {
+		utxos = []UTXO{}
+	}
+	writeJSON(w, http.StatusOK, utxos)
+}
+
+// balance is the reply of /balance/{address}.
+type balance struct {
+	Address string `json:"address"`
+	// Confirmed sums the confirmed UTXOs, Unconfirmed the outputs of mempool
+	// transactions, and PendingSpend the UTXOs mempool transactions spend.
+	Confirmed    btc.Amount `json:"confirmed"`
+	Unconfirmed  btc.Amount `json:"unconfirmed"`
+	PendingSpend btc.Amount `json:"pendingSpend"`
+}
+
+func (s *apiServer) balance(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !s.cache.isWatched(address) {
+		writeError(w, http.StatusNotFound, "address "+address+" is not watched")
+		return
+	}
+	b := balance{Address: address}
+	for _, u := range s.cache.addressUTXOs(address) {
+		if u.Unconfirmed {
+			b.Unconfirmed += u.Amount
+		} else {
+			b.Confirmed += u.Amount
+		}
+		if u.PendingSpend != "" {
+			b.PendingSpend += u.Amount
+		}
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+func (s *apiServer) transaction(w http.ResponseWriter, r *http.Request) {
+	txid := r.PathValue("txid")
+	tx, confirmed, ok := s.cache.findTransaction(txid)
+	if !ok {
+		writeError(w, http.StatusNotFound, "transaction "+txid+" is not indexed")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Transaction
+		Confirmed bool `json:"confirmed"`
+	}{tx, confirmed})
+}
+
+// height reports the last processed block.
+func (s *apiServer) height(w http.ResponseWriter, r *http.Request) {
+	height, hash := s.cache.getTip()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"height": height, "hash": hash})
+}
+
+// health is the reply of /health.
+type health struct {
+	Status        string `json:"status"`
+	Height        int64  `json:"height"`
+	NodeReachable bool   `json:"nodeReachable"`
+	NodeHeight    int64  `json:"nodeHeight,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// health reports the last processed block and whether the node answers,
+// with status 503 when it does not.
+func (s *apiServer) health(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+	h := health{Status: "ok", NodeReachable: true}
+	h.Height, _ = s.cache.getTip()
+	nodeHeight, err := s.node.GetBlockCount(ctx)
+	if err != nil {
+		h.Status, h.NodeReachable, h.Error = "unavailable", false, err.Error()
+		writeJSON(w, http.StatusServiceUnavailable, h)
+		return
+	}
+	h.NodeHeight = nodeHeight
+	writeJSON(w, http.StatusOK, h)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"bitcoin-playground/config"
+	"bitcoin-playground/rpc"
+)
+
+// get requests path from srv and decodes the JSON reply into v.
+func get(t *testing.T, srv *httptest.Server, path string, v interface{}) int {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+func TestAPIUTXOs(t *testing.T) {
+	ix := newTestIndexer(t)
+	a, b := ix.chain.NewAddress(""), ix.chain.NewAddress("")
+	ix.cache.watch(a)
+	ix.cache.watch(b)
+	first := ix.fund(t, a, 10_000)
+	second := ix.fund(t, b, 20_000)
+	height := ix.mine()
+	ix.sync(t)
+	srv := httptest.NewServer((&apiServer{cache: ix.cache, node: ix.node}).handler())
+	t.Cleanup(srv.Close)
+
+	outpointsOf := func(path string) []string {
+		t.Helper()
+		var utxos []UTXO
+		if status := get(t, srv, path, &utxos); status != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, status)
+		}
+		return outpoints(utxos)
+	}
+	if got, want := outpointsOf("/utxos?address="+a), []string{outpoint(first, height)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UTXOs of %s: %v, want %v", a, got, want)
+	}
+	// Without an address, all watched UTXOs are listed.
+	want := []string{outpoint(first, height), outpoint(second, height)}
+	sort.Strings(want)
+	if got := outpointsOf("/utxos"); !reflect.DeepEqual(got, want) {
+		t.Errorf("all UTXOs: %v, want %v", got, want)
+	}
+
+	var e map[string]string
+	if status := get(t, srv, "/utxos?address="+ix.chain.NewAddress(""), &e); status != http.StatusNotFound || e["error"] == "" {
+		t.Errorf("unwatched address: status %d, reply %v", status, e)
+	}
+}
+
+func TestAPIHealth(t *testing.T) {
+	ix := newTestIndexer(t)
+	ix.mine()
+	ix.sync(t)
+	height, _ := ix.cache.getTip()
+
+	srv := httptest.NewServer((&apiServer{cache: ix.cache, node: ix.node}).handler())
+	t.Cleanup(srv.Close)
+	var h health
+	if status := get(t, srv, "/health", &h); status != http.StatusOK || h != (health{Status: "ok", Height: height, NodeReachable: true, NodeHeight: height}) {
+		t.Errorf("status %d, health %+v", status, h)
+	}
+
+	// A node that does not answer makes the indexer unavailable.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	node, err := rpc.NewEndpointClient(config.Endpoint{URL: down.URL, User: "admin", Pass: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv = httptest.NewServer((&apiServer{cache: ix.cache, node: node}).handler())
+	t.Cleanup(srv.Close)
+	h = health{}
+	if status := get(t, srv, "/health", &h); status != http.StatusServiceUnavailable || h.NodeReachable || h.Height != height || h.Error == "" {
+		t.Errorf("node down: status %d, health %+v", status, h)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// scriptType is the output script an output descriptor produces.
+type scriptType int
+
+const (
+	scriptPKH    scriptType = iota // pkh(KEY)
+	scriptWPKH                     // wpkh(KEY)
+	scriptSHWPKH                   // sh(wpkh(KEY))
+	scriptTR                       // tr(KEY), key path only
+)
+
+// descriptorWrappers maps the supported descriptor prefixes to their script
+// type; each is closed by one parenthesis per opening one.
+var descriptorWrappers = []struct {
+	prefix string
+	script scriptType
+}{
+	{"sh(wpkh(", scriptSHWPKH},
+	{"wpkh(", scriptWPKH},
+	{"pkh(", scriptPKH},
+	{"tr(", scriptTR},
+}
+
+// descriptor is a parsed output descriptor (BIP380) with a single key. Its
+// addresses are grouped in chains, one per branch of a multipath key such as
+// xpub/<0;1>/* (receive and change). A ranged descriptor derives a chain's
+// addresses from the index that replaces *; otherwise each chain is a single
+// address.
+type descriptor struct {
+	text   string // Without checksum.
+	script scriptType
+	ranged bool
+	chains []*hdkeychain.ExtendedKey // Parents of the derived keys; ranged only.
+	keys   []*btcec.PublicKey        // One per chain; not ranged only.
+}
+
+// isDescriptor tells output descriptors apart from plain addresses.
+func isDescriptor(entry string) bool {
+	return strings.Contains(entry, "(")
+}
+
+// parseDescriptor parses one of pkh(KEY), wpkh(KEY), sh(wpkh(KEY)) and
+// tr(KEY), optionally followed by its #checksum. KEY is a hex public key or
+// an extended public key with an optional [origin] and derivation steps, e.g.
+// [d34db33f/84'/1'/0']tpub.../<0;1>/*. Hardened steps may only appear in the
+// origin: private keys are refused, as descriptors are kept in the index.
+func parseDescriptor(s string, params *chaincfg.Params) (*descriptor, error) {
+	text, checksum, found := strings.Cut(strings.TrimSpace(s), "#")
+	if found {
+		want, err := descriptorChecksum(text)
+		if err != nil {
+			return nil, err
+		}
+		if checksum != want {
+			return nil, fmt.Errorf("invalid descriptor checksum %q, expected %q", checksum, want)
+		}
+	}
+	d := &descriptor{text: text}
+	var key string
+	for _, w := range descriptorWrappers {
+		if rest, ok := strings.CutPrefix(text, w.prefix); ok {
+			closing := strings.Repeat(")", strings.Count(w.prefix, "("))
+			if key, ok = strings.CutSuffix(rest, closing); !ok {
+				return nil, fmt.Errorf("descriptor %s: missing %q", text, closing)
+			}
+			d.script = w.script
+			break
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("unsupported descriptor %s: use pkh(), wpkh(), sh(wpkh()) or tr() with a single key", text)
+	}
+	if strings.ContainsAny(key, "(),") {
+		return nil, fmt.Errorf("unsupported descriptor %s: only single-key descriptors are supported", text)
+	}
+	if err := d.parseKey(key, params); err != nil {
+		return nil, fmt.Errorf("descriptor %s: %w", text, err)
+	}
+	return d, nil
+}
+
+// parseKey parses a key expression into the chains or keys of d.
+func (d *descriptor) parseKey(expr string, params *chaincfg.Params) error {
+	if strings.HasPrefix(expr, "[") {
+		end := strings.IndexByte(expr, ']')
+		if end < 0 {
+			return fmt.Errorf("unterminated key origin in %s", expr)
+		}
+		if err := checkOrigin(expr[1:end]); err != nil {
+			return err
+		}
+		expr = expr[end+1:]
+	}
+	steps := strings.Split(expr, "/")
+	if len(steps) == 1 && isHex(expr) {
+		key, err := parsePubKey(expr, d.script)
+		if err != nil {
+			return err
+		}
+		d.keys = []*btcec.PublicKey{key}
+		return nil
+	}
+
+	root, err := hdkeychain.NewKeyFromString(steps[0])
+	if err != nil {
+		return fmt.Errorf("invalid extended key: %v", err)
+	}
+	if root.IsPrivate() {
+		return fmt.Errorf("extended private keys are not accepted, use the extended public key")
+	}
+	if !root.IsForNet(params) {
+		return fmt.Errorf("extended key is not for %s", params.Name)
+	}
+	keys := []*hdkeychain.ExtendedKey{root}
+	multipath := false
+	for i, step := range steps[1:] {
+		if step == "*" {
+			if i != len(steps)-2 {
+				return fmt.Errorf("* must be the last derivation step")
+			}
+			d.ranged = true
+			break
+		}
+		var indexes []uint32
+		if branches, ok := strings.CutPrefix(step, "<"); ok {
+			if multipath || !strings.HasSuffix(branches, ">") {
+				return fmt.Errorf("invalid multipath step %s", step)
+			}
+			multipath = true
+			for _, b := range strings.Split(strings.TrimSuffix(branches, ">"), ";") {
+				index, err := parseStep(b)
+				if err != nil {
+					return err
+				}
+				indexes = append(indexes, index)
+			}
+		} else {
+			index, err := parseStep(step)
+			if err != nil {
+				return err
+			}
+			indexes = []uint32{index}
+		}
+		var children []*hdkeychain.ExtendedKey
+		for _, k := range keys {
+			for _, index := range indexes {
+				if index >= hdkeychain.HardenedKeyStart {
+					return fmt.Errorf("hardened step %s after an extended public key; move it to the key origin", step)
+				}
+				child, err := k.Derive(index)
+				if err != nil {
+					return fmt.Errorf("derive step %s: %v", step, err)
+				}
+				children = append(children, child)
+			}
+		}
+		keys = children
+	}
+
+	for _, k := range keys {
+		if d.ranged {
+			d.chains = append(d.chains, k)
+			continue
+		}
+		pub, err := k.ECPubKey()
+		if err != nil {
+			return err
+		}
+		d.keys = append(d.keys, pub)
+	}
+	return nil
+}
+
+// checkOrigin checks a key origin: a fingerprint of 8 hex digits followed by
+// derivation steps, e.g. d34db33f/84'/1'/0'.
+func checkOrigin(origin string) error {
+	steps := strings.Split(origin, "/")
+	if len(steps[0]) != 8 || !isHex(steps[0]) {
+		return fmt.Errorf("invalid key origin fingerprint %q", steps[0])
+	}
+	for _, step := range steps[1:] {
+		if _, err := parseStep(step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStep parses a derivation step; a trailing ' or h marks it hardened.
+func parseStep(step string) (uint32, error) {
+	hardened := strings.HasSuffix(step, "'") || strings.HasSuffix(step, "h")
+	if hardened {
+		step = step[:len(step)-1]
+	}
+	index, err := strconv.ParseUint(step, 10, 31)
+	if err != nil {
+		return 0, fmt.Errorf("invalid derivation step %q", step)
+	}
+	if hardened {
+		return uint32(index) + hdkeychain.HardenedKeyStart, nil
+	}
+	return uint32(index), nil
+}
+
+// parsePubKey parses a hex public key: compressed, or x-only in tr().
+func parsePubKey(s string, script scriptType) (*btcec.PublicKey, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if script == scriptTR && len(b) == schnorr.PubKeyBytesLen {
+		return schnorr.ParsePubKey(b)
+	}
+	if len(b) != btcec.PubKeyBytesLenCompressed {
+		return nil, fmt.Errorf("public key %s is not compressed", s)
+	}
+	return btcec.ParsePubKey(b)
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// numChains returns the number of address chains of d.
+func (d *descriptor) numChains() int {
+	if d.ranged {
+		return len(d.chains)
+	}
+	return len(d.keys)
+}
+
+// address returns the address at index of chain; index is ignored unless d
+// is ranged. It fails for the rare indexes BIP32 cannot derive, which are
+// skipped.
+func (d *descriptor) address(chain int, index uint32, params *chaincfg.Params) (string, error) {
+	var key *btcec.PublicKey
+	if d.ranged {
+		child, err := d.chains[chain].Derive(index)
+		if err != nil {
+			return "", err
+		}
+		if key, err = child.ECPubKey(); err != nil {
+			return "", err
+		}
+	} else {
+		key = d.keys[chain]
+	}
+
+	var addr btcutil.Address
+	var err error
+	keyHash := btcutil.Hash160(key.SerializeCompressed())
+	switch d.script {
+	case scriptPKH:
+		addr, err = btcutil.NewAddressPubKeyHash(keyHash, params)
+	case scriptWPKH:
+		addr, err = btcutil.NewAddressWitnessPubKeyHash(keyHash, params)
+	case scriptSHWPKH:
+		redeemScript := append([]byte{txscript.OP_0, txscript.OP_DATA_20}, keyHash...)
+		addr, err = btcutil.NewAddressScriptHash(redeemScript, params)
+	case scriptTR:
+		outputKey := txscript.ComputeTaprootKeyNoScript(key)
+		addr, err = btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), params)
+	}
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
+
+// Descriptor checksum alphabets and generator, see BIP380.
+const (
+	descriptorInputCharset    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+var descriptorGenerator = [5]uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+
+// descriptorChecksum computes the 8 character checksum of a descriptor.
+func descriptorChecksum(desc string) (string, error) {
+	c := uint64(1)
+	polymod := func(value uint64) {
+		top := c >> 35
+		c = (c&0x7ffffffff)<<5 ^ value
+		for i, g := range descriptorGenerator {
+			if top>>i&1 == 1 {
+				c ^= g
+			}
+		}
+	}
+	var groups []uint64
+	for _, r := range desc {
+		pos := strings.IndexRune(descriptorInputCharset, r)
+		if pos < 0 {
+			return "", fmt.Errorf("invalid character %q in descriptor", r)
+		}
+		polymod(uint64(pos & 31))
+		groups = append(groups, uint64(pos>>5))
+		if len(groups) == 3 {
+			polymod(groups[0]*9 + groups[1]*3 + groups[2])
+			groups = groups[:0]
+		}
+	}
+	switch len(groups) {
+	case 1:
+		polymod(groups[0])
+	case 2:
+		polymod(groups[0]*3 + groups[1])
+	}
+	for range 8 {
+		polymod(0)
+	}
+	c ^= 1
+	checksum := make([]byte, 8)
+	for i := range checksum {
+		checksum[i] = descriptorChecksumCharset[c>>(5*(7-i))&31]
+	}
+	return string(checksum), nil
+}
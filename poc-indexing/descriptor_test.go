@@ -0,0 +1,207 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// testTpub is the BIP84 account key m/84'/1'/0' of the mnemonic "abandon
+// abandon ... about", whose master key fingerprint is 73c5da0a.
+const testTpub = "tpubDC8msFGeGuwnKG9Upg7DM2b4DaRqg3CUZa5g8v2SRQ6K4NSkxUgd7HsL2XVWbVm39yBA4LAxysQAm397zwQSQoQgewGiYZqrA9DsP4zbQ1M"
+
+func TestDescriptorChecksum(t *testing.T) {
+	// The vectors of BIP380, and an example of Bitcoin Core's descriptor
+	// documentation.
+	for desc, want := range map[string]string{
+		"raw(deadbeef)": "89f8spxm",
+		"wpkh([d34db33f/84h/0h/0h]xpub6DJ2dNUysrn5Vt36jH2KLBT2i1auw1tTSSomg8PhqNiUtx8QX2SvC9nrHu81fT41fvDUnhMjEzQgXnQjKEu3oaqMSzhSrHMxyyoEAmUHQbY/0/*)": "cjjspncu",
+	} {
+		if got, err := descriptorChecksum(desc); err != nil || got != want {
+			t.Errorf("descriptorChecksum(%s) = %q, %v, want %q", desc, got, err, want)
+		}
+	}
+	for _, s := range []string{
+		"raw(deadbeef)#",          // Missing checksum.
+		"raw(deadbeef)#89f8spxmx", // Too long.
+		"raw(deadbeef)#89f8spx",   // Too short.
+		"raw(deedbeef)#89f8spxm",  // Error in the payload.
+		"raw(deadbeef)#89f8spxn",  // Error in the checksum.
+		"raw(deadbeef)##89f8spxm", // Error in the delimiter.
+	} {
+		desc, checksum, _ := strings.Cut(s, "#")
+		if got, err := descriptorChecksum(desc); err != nil || got == checksum {
+			t.Errorf("%s: checksum %q, %v, want a mismatch", s, got, err)
+		}
+	}
+	if _, err := descriptorChecksum("raw(Ü)"); err == nil {
+		t.Error("descriptorChecksum accepted a character outside its alphabet")
+	}
+
+	// parseDescriptor checks the checksum when there is one.
+	desc := "wpkh(" + testTpub + "/0/*)"
+	checksum, err := descriptorChecksum(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{desc, desc + "#" + checksum} {
+		if d, err := parseDescriptor(s, &chaincfg.TestNet3Params); err != nil || d.text != desc {
+			t.Errorf("parseDescriptor(%s) = %v, %v", s, d, err)
+		}
+	}
+	wrong := desc + "#" + checksum[:7] + string(descriptorChecksumCharset[(strings.IndexByte(descriptorChecksumCharset, checksum[7])+1)%32])
+	if _, err := parseDescriptor(wrong, &chaincfg.TestNet3Params); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("parseDescriptor(%s): error %v", wrong, err)
+	}
+}
+
+func TestDescriptorAddresses(t *testing.T) {
+	// The addresses BIP84 derives at coin type 1 for testnet.
+	receive0, receive1, receive25 := "tb1q6rz28mcfaxtmd6v789l9rrlrusdprr9pqcpvkl", "tb1qd7spv5q28348xl4myc8zmh983w5jx32cjhkn97", "tb1qpucph20u5ynsr4hrk8ymq3g6zhtfa440yss2fs"
+	change0 := "tb1q9u62588spffmq4dzjxsr5l297znf3z6j5p2688"
+
+	type address struct {
+		chain int
+		index uint32
+		want  string
+	}
+	tests := []struct {
+		desc   string
+		ranged bool
+		chains int
+		want   []address
+	}{
+		{"wpkh([73c5da0a/84'/1'/0']" + testTpub + "/<0;1>/*)", true, 2, []address{{0, 0, receive0}, {0, 1, receive1}, {0, 25, receive25}, {1, 0, change0}}},
+		{"wpkh([73c5da0a/84h/1h/0h]" + testTpub + "/0/*)", true, 1, []address{{0, 0, receive0}, {0, 1, receive1}}},
+		{"wpkh(" + testTpub + "/1/0)", false, 1, []address{{0, 0, change0}, {0, 7, change0}}},
+		{"wpkh(" + testTpub + "/<0;1>/0)", false, 2, []address{{0, 0, receive0}, {1, 0, change0}}},
+	}
+	for _, tt := range tests {
+		d, err := parseDescriptor(tt.desc, &chaincfg.TestNet3Params)
+		if err != nil {
+			t.Errorf("parseDescriptor(%s): %v", tt.desc, err)
+			continue
+		}
+		if d.ranged != tt.ranged || d.numChains() != tt.chains {
+			t.Errorf("%s: ranged %v with %d chains, want %v with %d", tt.desc, d.ranged, d.numChains(), tt.ranged, tt.chains)
+			continue
+		}
+		for _, a := range tt.want {
+			if got, err := d.address(a.chain, a.index, &chaincfg.TestNet3Params); err != nil || got != a.want {
+				t.Errorf("%s: address %d/%d = %q, %v, want %s", tt.desc, a.chain, a.index, got, err, a.want)
+			}
+		}
+	}
+
+	// The other script types of the same key.
+	for prefix, want := range map[string]string{"pkh(": "m", "sh(wpkh(": "2", "tr(": "tb1p"} {
+		desc := prefix + testTpub + "/0/0" + strings.Repeat(")", strings.Count(prefix, "("))
+		d, err := parseDescriptor(desc, &chaincfg.TestNet3Params)
+		if err != nil {
+			t.Errorf("parseDescriptor(%s): %v", desc, err)
+			continue
+		}
+		if got, err := d.address(0, 0, &chaincfg.TestNet3Params); err != nil || !strings.HasPrefix(got, want) {
+			t.Errorf("%s: address %q, %v, want one starting with %s", desc, got, err, want)
+		}
+	}
+}
+
+func TestParseDescriptorErrors(t *testing.T) {
+	seed := make([]byte, hdkeychain.RecommendedSeedLen)
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tprv := master.String()
+	mainnet := "xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V"
+
+	tests := []struct {
+		desc, want string
+	}{
+		// Hardened steps need the private key, so they are only accepted
+		// in the key origin.
+		{"wpkh(" + testTpub + "/0'/*)", "hardened step"},
+		{"wpkh(" + testTpub + "/1h/0)", "hardened step"},
+		{"wpkh(" + testTpub + "/<0;1'>/*)", "hardened step"},
+		{"wpkh(" + tprv + "/0/*)", "extended private keys are not accepted"},
+		{"wpkh(" + mainnet + "/0/*)", "not for"},
+		{"wpkh(" + testTpub + "/*/0)", "* must be the last"},
+		{"wpkh(" + testTpub + "/<0;1>/<0;1>/*)", "invalid multipath step"},
+		{"wpkh(" + testTpub + "/<0;1/*)", "invalid multipath step"},
+		{"wpkh(" + testTpub + "/x/*)", "invalid derivation step"},
+		{"wpkh(" + testTpub + "/2147483648/*)", "invalid derivation step"},
+		{"wpkh([73c5da0a/84'" + testTpub + "/0/*)", "unterminated key origin"},
+		{"wpkh([73c5da/84']" + testTpub + "/0/*)", "invalid key origin fingerprint"},
+		{"wpkh([73c5da0a/84'/x]" + testTpub + "/0/*)", "invalid derivation step"},
+		{"wpkh(" + testTpub + "/0/*", "missing"},
+		{"wpkh(04" + strings.Repeat("11", 64) + ")", "not compressed"},
+		{"wsh(multi(1," + testTpub + "/0/*))", "unsupported descriptor"},
+		{"pkh(" + testTpub + "/0/*,1)", "single-key"},
+		{"wpkh(tpubnotakey/0/*)", "invalid extended key"},
+	}
+	for _, tt := range tests {
+		if _, err := parseDescriptor(tt.desc, &chaincfg.TestNet3Params); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseDescriptor(%s): error %v, want one containing %q", tt.desc, err, tt.want)
+		}
+	}
+}
+
+func TestDescriptorGapLimit(t *testing.T) {
+	ix := newTestIndexer(t)
+	d, err := parseDescriptor("wpkh("+testTpub+"/0/*)", activeNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := func(index uint32) string {
+		t.Helper()
+		addr, err := d.address(0, index, activeNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+	window := func(used, derived uint32) {
+		t.Helper()
+		_, descriptors := ix.cache.watchList()
+		if len(descriptors) != 1 || !reflect.DeepEqual(descriptors[0].Used, []uint32{used}) || !reflect.DeepEqual(descriptors[0].Derived, []uint32{derived}) {
+			t.Errorf("descriptors %+v, want %d used and %d derived", descriptors, used, derived)
+		}
+	}
+
+	// Index 35 lies past the initial window, but paying index 19 extends
+	// it to 39, and the scan of the new addresses finds it.
+	ix.fund(t, address(19), 10_000)
+	ix.fund(t, address(35), 20_000)
+	ix.mine()
+	if added := ix.cache.watchDescriptor(d); len(added) != 20 {
+		t.Fatalf("watching the descriptor added %d addresses, want 20", len(added))
+	}
+	window(0, 20)
+	if err := startFullScan(ix.client, ix.node, ix.cache); err != nil {
+		t.Fatal(err)
+	}
+	window(36, 56)
+	if got := len(ix.cache.listUTXOs()); got != 2 {
+		t.Errorf("%d UTXOs after the scan, want 2", got)
+	}
+
+	// Paying index N in a block extends the window to N plus the gap limit.
+	ix.fund(t, address(55), 30_000)
+	ix.mine()
+	ix.sync(t)
+	window(56, 76)
+	if !ix.cache.isWatched(address(75)) || ix.cache.isWatched(address(76)) {
+		t.Errorf("watched up to index 75: %v, index 76: %v", ix.cache.isWatched(address(75)), ix.cache.isWatched(address(76)))
+	}
+	if got := len(ix.cache.addressUTXOs(address(55))); got != 1 {
+		t.Errorf("index 55 has %d UTXOs, want 1", got)
+	}
+
+	// The window is restored on restart.
+	ix.reopen(t)
+	window(56, 76)
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/config"
+	"bitcoin-playground/network"
+	"bitcoin-playground/network/btcdnet"
+	"bitcoin-playground/rpc"
+	"bitcoin-playground/rpctest"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+// testIndexer is an index kept in a bbolt file and the regtest stand-in
+// node it follows.
+type testIndexer struct {
+	chain  *rpctest.Chain
+	client *rpcclient.Client
+	node   *rpc.Client
+	cache  *Cache
+	dbPath string
+}
+
+// newTestIndexer starts a regtest stand-in node and an empty index of it.
+func newTestIndexer(t *testing.T) *testIndexer {
+	t.Helper()
+	params := activeNetParams
+	activeNetParams = &chaincfg.RegressionNetParams
+	t.Cleanup(func() { activeNetParams = params })
+
+	chain := rpctest.NewChain(&network.Regtest)
+	srv := rpctest.NewServer(chain, "admin", "admin")
+	t.Cleanup(srv.Close)
+	endpoint := config.Endpoint{URL: srv.URL, User: "admin", Pass: "admin"}
+	client, err := btcdnet.Connect(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Shutdown)
+	node, err := rpc.NewEndpointClient(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix := &testIndexer{chain: chain, client: client, node: node, dbPath: filepath.Join(t.TempDir(), "index.db")}
+	ix.cache = ix.open(t)
+	t.Cleanup(func() { ix.cache.store.Close() })
+	return ix
+}
+
+// open loads the index from its bbolt file.
+func (ix *testIndexer) open(t *testing.T) *Cache {
+	t.Helper()
+	store, err := openStore(ix.dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := newCache(store, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+// reopen closes the index and loads it again from its bbolt file, as a
+// restart does.
+func (ix *testIndexer) reopen(t *testing.T) {
+	t.Helper()
+	if err := ix.cache.store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ix.cache = ix.open(t)
+}
+
+// sync does what a poll of continuousUTXOMonitor does with the new blocks.
+func (ix *testIndexer) sync(t *testing.T) {
+	t.Helper()
+	height := ix.chain.Height()
+	if err := handleReorg(ix.client, ix.cache, height, 100); err != nil {
+		t.Fatal(err)
+	}
+	if last := ix.cache.getBlockHeight(); height > last {
+		if err := syncBlocks(context.Background(), ix.client, ix.node, ix.cache, last+1, height, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fund pays amount to address in a mempool transaction and returns its
+// txid; the payment is output 0.
+func (ix *testIndexer) fund(t *testing.T, address string, amount btc.Amount) string {
+	t.Helper()
+	txid, err := ix.chain.Fund(address, amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return txid
+}
+
+// spend adds a mempool transaction spending output 0 of txid, worth amount,
+// to address, and returns its txid.
+func (ix *testIndexer) spend(t *testing.T, txid string, amount btc.Amount, address string) string {
+	t.Helper()
+	script, err := rpctest.AddressScript(&network.Regtest, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spendID, err := ix.chain.AddTx(&rpctest.Tx{
+		Version: 2,
+		Inputs:  []rpctest.TxIn{{PrevTxID: txid, Sequence: 0xffffffff}},
+		Outputs: []rpctest.TxOut{{Value: amount - 1000, Script: script}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spendID
+}
+
+// mine mines a block and returns its height.
+func (ix *testIndexer) mine() int64 {
+	return ix.chain.Mine(1)[0].Height
+}
+
+// checkTip fails unless the index is at the node's tip.
+func (ix *testIndexer) checkTip(t *testing.T) {
+	t.Helper()
+	tip := ix.chain.Tip()
+	if height, hash := ix.cache.getTip(); height != tip.Height || hash != tip.Hash() {
+		t.Errorf("tip %d %s, want %d %s", height, hash, tip.Height, tip.Hash())
+	}
+}
+
+// outpoints describes utxos as sorted "txid:vout@height" strings, with
+// unconfirmed ones at height "mempool".
+func outpoints(utxos []UTXO) []string {
+	keys := make([]string, 0, len(utxos))
+	for _, u := range utxos {
+		height := fmt.Sprint(u.Height)
+		if u.Unconfirmed {
+			height = "mempool"
+		}
+		keys = append(keys, fmt.Sprintf("%s:%d@%s", u.TxID, u.Vout, height))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// outpoint is the "txid:vout@height" of output 0 of txid.
+func outpoint(txid string, height interface{}) string {
+	return fmt.Sprintf("%s:0@%v", txid, height)
+}
+
+// txids returns the sorted txids of the cached confirmed transactions.
+func txids(cache *Cache) []string {
+	var ids []string
+	for _, tx := range cache.listTransactions() {
+		ids = append(ids, tx.TxID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func sorted(s ...string) []string {
+	sort.Strings(s)
+	return s
+}
+
+func TestFullScan(t *testing.T) {
+	ix := newTestIndexer(t)
+	watched, other := ix.chain.NewAddress(""), ix.chain.NewAddress("")
+	ix.cache.watch(watched)
+	first := ix.fund(t, watched, 10_000)
+	ix.fund(t, other, 20_000)
+	firstHeight := ix.mine()
+	second := ix.fund(t, watched, 30_000)
+	secondHeight := ix.mine()
+	ix.chain.Mine(2)
+
+	if err := startFullScan(ix.client, ix.node, ix.cache); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{outpoint(first, firstHeight), outpoint(second, secondHeight)}
+	sort.Strings(want)
+	for _, restarted := range []bool{false, true} {
+		if restarted {
+			ix.reopen(t)
+		}
+		if got := outpoints(ix.cache.listUTXOs()); !reflect.DeepEqual(got, want) {
+			t.Errorf("restarted %v: UTXOs %v, want %v", restarted, got, want)
+		}
+		if got := txids(ix.cache); !reflect.DeepEqual(got, sorted(first, second)) {
+			t.Errorf("restarted %v: transactions %v, want %s and %s", restarted, got, first, second)
+		}
+		ix.checkTip(t)
+	}
+	if tx, _, _ := ix.cache.findTransaction(first); tx.BlockHash != ix.chain.BlockAt(firstHeight).Hash() {
+		t.Errorf("transaction %s in block %s, want %s", first, tx.BlockHash, ix.chain.BlockAt(firstHeight).Hash())
+	}
+}
+
+func TestSyncBlocks(t *testing.T) {
+	ix := newTestIndexer(t)
+	watched, other := ix.chain.NewAddress(""), ix.chain.NewAddress("")
+	ix.cache.watch(watched)
+	if err := startFullScan(ix.client, ix.node, ix.cache); err != nil {
+		t.Fatal(err)
+	}
+	start := ix.cache.getBlockHeight()
+
+	first := ix.fund(t, watched, 10_000)
+	ix.fund(t, other, 20_000)
+	firstHeight := ix.mine()
+	ix.chain.Mine(3)
+	second := ix.fund(t, watched, 30_000)
+	secondHeight := ix.mine()
+	ix.chain.Mine(2)
+	ix.sync(t)
+
+	want := []string{outpoint(first, firstHeight), outpoint(second, secondHeight)}
+	sort.Strings(want)
+	for _, restarted := range []bool{false, true} {
+		if restarted {
+			ix.reopen(t)
+		}
+		if got := outpoints(ix.cache.listUTXOs()); !reflect.DeepEqual(got, want) {
+			t.Errorf("restarted %v: UTXOs %v, want %v", restarted, got, want)
+		}
+		// Coinbases and the payment to the other address are not kept.
+		if got := txids(ix.cache); !reflect.DeepEqual(got, sorted(first, second)) {
+			t.Errorf("restarted %v: transactions %v, want %s and %s", restarted, got, first, second)
+		}
+		ix.checkTip(t)
+		for height := start; height <= ix.chain.Height(); height++ {
+			if got, want := ix.cache.blockHashAt(height), ix.chain.BlockAt(height).Hash(); got != want {
+				t.Errorf("restarted %v: block %d is %q, want %s", restarted, height, got, want)
+			}
+		}
+	}
+}
+
+func TestSyncSpends(t *testing.T) {
+	ix := newTestIndexer(t)
+	watched, other := ix.chain.NewAddress(""), ix.chain.NewAddress("")
+	ix.cache.watch(watched)
+	funding := ix.fund(t, watched, 10_000)
+	fundingHeight := ix.mine()
+	if err := startFullScan(ix.client, ix.node, ix.cache); err != nil {
+		t.Fatal(err)
+	}
+
+	spending := ix.spend(t, funding, 10_000, other)
+	spendHeight := ix.mine()
+	ix.sync(t)
+
+	for _, restarted := range []bool{false, true} {
+		if restarted {
+			ix.reopen(t)
+		}
+		if got := ix.cache.listUTXOs(); len(got) != 0 {
+			t.Errorf("restarted %v: UTXOs %v, want none", restarted, outpoints(got))
+		}
+		spent := ix.cache.listSpent()
+		if len(spent) != 1 {
+			t.Fatalf("restarted %v: spent %+v, want one", restarted, spent)
+		}
+		if sp := spent[0]; sp.TxID != funding || sp.Address != watched || sp.Height != int(fundingHeight) ||
+			sp.SpentBy != spending || sp.SpentHeight != spendHeight {
+			t.Errorf("restarted %v: spent %+v, want %s:0 of %s spent by %s at %d", restarted, sp, funding, watched, spending, spendHeight)
+		}
+		if got := txids(ix.cache); !reflect.DeepEqual(got, sorted(funding, spending)) {
+			t.Errorf("restarted %v: transactions %v, want %s and %s", restarted, got, funding, spending)
+		}
+	}
+}
+
+func TestReorgRollback(t *testing.T) {
+	ix := newTestIndexer(t)
+	watched, other := ix.chain.NewAddress(""), ix.chain.NewAddress("")
+	ix.cache.watch(watched)
+	if err := startFullScan(ix.client, ix.node, ix.cache); err != nil {
+		t.Fatal(err)
+	}
+	kept := ix.fund(t, watched, 10_000)
+	keptHeight := ix.mine()
+	ix.sync(t)
+	spending := ix.spend(t, kept, 10_000, other)
+	ix.mine()
+	orphaned := ix.fund(t, watched, 20_000)
+	ix.mine()
+	ix.sync(t)
+
+	// A branch without the last two blocks and their transactions overtakes
+	// the chain.
+	if _, err := ix.chain.Reorg(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, txid := range []string{spending, orphaned} {
+		if !ix.chain.Evict(txid) {
+			t.Fatalf("%s did not return to the mempool", txid)
+		}
+	}
+	ix.chain.Mine(3)
+	ix.sync(t)
+
+	for _, restarted := range []bool{false, true} {
+		if restarted {
+			ix.reopen(t)
+		}
+		want := []string{outpoint(kept, keptHeight)}
+		if got := outpoints(ix.cache.listUTXOs()); !reflect.DeepEqual(got, want) {
+			t.Errorf("restarted %v: UTXOs %v, want %v", restarted, got, want)
+		}
+		if spent := ix.cache.listSpent(); len(spent) != 0 {
+			t.Errorf("restarted %v: spent %+v, want none", restarted, spent)
+		}
+		if got := txids(ix.cache); !reflect.DeepEqual(got, []string{kept}) {
+			t.Errorf("restarted %v: transactions %v, want %s", restarted, got, kept)
+		}
+		ix.checkTip(t)
+	}
+}
+
+func TestReorgTooDeep(t *testing.T) {
+	ix := newTestIndexer(t)
+	ix.cache.watch(ix.chain.NewAddress(""))
+	ix.chain.Mine(3)
+	if err := startFullScan(ix.client, ix.node, ix.cache); err != nil {
+		t.Fatal(err)
+	}
+	height, hash := ix.cache.getTip()
+	if _, err := ix.chain.Reorg(3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := handleReorg(ix.client, ix.cache, ix.chain.Height(), 2); err == nil {
+		t.Error("a reorganization deeper than the limit was rolled back")
+	}
+	if h, hh := ix.cache.getTip(); h != height || hh != hash {
+		t.Errorf("tip moved to %d %s", h, hh)
+	}
+}
+
+func TestMempoolPoller(t *testing.T) {
+	ix := newTestIndexer(t)
+	watched, other := ix.chain.NewAddress(""), ix.chain.NewAddress("")
+	ix.cache.watch(watched)
+	confirmed := ix.fund(t, watched, 10_000)
+	confirmedHeight := ix.mine()
+	if err := startFullScan(ix.client, ix.node, ix.cache); err != nil {
+		t.Fatal(err)
+	}
+	poller := newMempoolPoller(ix.node)
+	poll := func() {
+		t.Helper()
+		if err := poller.poll(context.Background(), ix.cache); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unconfirmed := ix.fund(t, watched, 20_000)
+	ix.fund(t, other, 30_000)
+	spending := ix.spend(t, confirmed, 10_000, other)
+	poll()
+	utxos := ix.cache.addressUTXOs(watched)
+	want := []string{outpoint(confirmed, confirmedHeight), outpoint(unconfirmed, "mempool")}
+	sort.Strings(want)
+	if got := outpoints(utxos); !reflect.DeepEqual(got, want) {
+		t.Fatalf("UTXOs %v, want %v", got, want)
+	}
+	for _, u := range utxos {
+		if u.TxID == confirmed && u.PendingSpend != spending {
+			t.Errorf("UTXO %s:0 pending spend %q, want %s", confirmed, u.PendingSpend, spending)
+		}
+	}
+	if got := sorted(ix.cache.mempoolTxIDs()...); !reflect.DeepEqual(got, sorted(unconfirmed, spending)) {
+		t.Errorf("tracked %v, want %s and %s", got, unconfirmed, spending)
+	}
+
+	// An evicted transaction is dropped by the next poll.
+	ix.chain.Evict(unconfirmed)
+	poll()
+	if got := ix.cache.mempoolTxIDs(); !reflect.DeepEqual(got, []string{spending}) {
+		t.Errorf("tracked %v after eviction, want %s", got, spending)
+	}
+
+	// A confirmed one is kept until its block is processed.
+	spendHeight := ix.mine()
+	poll()
+	if got := ix.cache.mempoolTxIDs(); !reflect.DeepEqual(got, []string{spending}) {
+		t.Errorf("tracked %v before the block is processed, want %s", got, spending)
+	}
+	ix.sync(t)
+	if got := ix.cache.mempoolTxIDs(); len(got) != 0 {
+		t.Errorf("tracked %v after the block was processed, want none", got)
+	}
+	if spent := ix.cache.listSpent(); len(spent) != 1 || spent[0].SpentBy != spending || spent[0].SpentHeight != spendHeight {
+		t.Errorf("spent %+v, want %s:0 spent by %s at %d", spent, confirmed, spending, spendHeight)
+	}
+}
+
+func TestCacheUTXOIndex(t *testing.T) {
+	ix := newTestIndexer(t)
+	a, b := ix.chain.NewAddress(""), ix.chain.NewAddress("")
+	ix.cache.watch(a)
+	ix.cache.watch(b)
+	var changes Changes
+	for vout := 0; vout < 3; vout++ {
+		ix.cache.addUTXO(&changes, a, UTXO{TxID: "aa", Vout: vout, Height: 1})
+	}
+	ix.cache.addUTXO(&changes, b, UTXO{TxID: "bb", Height: 1})
+	ix.cache.addUTXO(&changes, a, UTXO{TxID: "aa", Vout: 1, Height: 1}) // Already cached.
+
+	// findUTXO reports the address of every cached UTXO, and none of the others.
+	check := func(when string, want map[string]string) {
+		t.Helper()
+		for _, key := range []string{"aa:0", "aa:1", "aa:2", "bb:0"} {
+			txid, vout := key[:2], int(key[3]-'0')
+			address, u, ok := ix.cache.findUTXO(txid, vout)
+			if ok != (want[key] != "") || address != want[key] || (ok && (u.TxID != txid || u.Vout != vout)) {
+				t.Errorf("%s: findUTXO(%s) = %s, %s:%d, %v, want address %q", when, key, address, u.TxID, u.Vout, ok, want[key])
+			}
+		}
+		if got := len(ix.cache.listUTXOs()); got != len(want) {
+			t.Errorf("%s: %d UTXOs, want %d", when, got, len(want))
+		}
+	}
+	check("added", map[string]string{"aa:0": a, "aa:1": a, "aa:2": a, "bb:0": b})
+
+	for _, key := range []string{"aa:0", "bb:0"} {
+		if _, ok := ix.cache.spendUTXO(&changes, key[:2], 0, "cc", 2); !ok {
+			t.Fatalf("spendUTXO(%s) found nothing", key)
+		}
+	}
+	if _, ok := ix.cache.spendUTXO(&changes, "aa", 0, "cc", 2); ok {
+		t.Error("spendUTXO spent aa:0 twice")
+	}
+	check("spent", map[string]string{"aa:1": a, "aa:2": a})
+
+	ix.cache.rollback(1)
+	check("rolled back", map[string]string{"aa:0": a, "aa:1": a, "aa:2": a, "bb:0": b})
+
+	ix.cache.unwatch(a)
+	check("unwatched", map[string]string{"bb:0": b})
+}
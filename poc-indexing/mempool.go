@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/rpc"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// mempoolBatchSize bounds the getrawtransaction calls sent in one batch.
+const mempoolBatchSize = 500
+
+// mempoolTx is an unconfirmed transaction that pays a watched address or
+// spends a cached UTXO.
+type mempoolTx struct {
+	TxID    string
+	Inputs  []string     // Every outpoint it spends, as "txid:vout".
+	Outputs []storedUTXO // Its outputs paying watched addresses.
+}
+
+// txInputs returns the outpoints tx spends, as "txid:vout".
+func txInputs(tx *wire.MsgTx) []string {
+	inputs := make([]string, len(tx.TxIn))
+	for i, input := range tx.TxIn {
+		prev := input.PreviousOutPoint
+		inputs[i] = string(outpointKey(prev.Hash.String(), int(prev.Index)))
+	}
+	return inputs
+}
+
+// addMempoolTx records m, replacing the mempool transactions that spend the
+// same outputs (RBF) along with their descendants. It returns the txids it
+// replaced.
+func (c *Cache) addMempoolTx(m mempoolTx) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	replaced := c.removeConflicts(m.TxID, m.Inputs)
+	c.mempool[m.TxID] = m
+	return replaced
+}
+
+// removeMempoolTx drops txid and its descendants, returning the txids dropped.
+func (c *Cache) removeMempoolTx(txid string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.removeWithDescendants(txid)
+}
+
+// confirmMempoolTx reconciles the mempool with a transaction confirmed in a
+// block: it reports whether txid was tracked as unconfirmed, and drops the
+// mempool transactions it double spent.
+func (c *Cache) confirmMempoolTx(txid string, inputs []string) (bool, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, tracked := c.mempool[txid]
+	delete(c.mempool, txid)
+	return tracked, c.removeConflicts(txid, inputs)
+}
+
+// mempoolTxIDs returns the txids of the tracked mempool transactions.
+func (c *Cache) mempoolTxIDs() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	txids := make([]string, 0, len(c.mempool))
+	for txid := range c.mempool {
+		txids = append(txids, txid)
+	}
+	return txids
+}
+
+// removeConflicts drops the mempool transactions other than txid that spend
+// any of inputs. The caller holds c.mu.
+func (c *Cache) removeConflicts(txid string, inputs []string) []string {
+	spends := make(map[string]bool, len(inputs))
+	for _, in := range inputs {
+		spends[in] = true
+	}
+	var removed []string
+	for id, m := range c.mempool {
+		if id == txid {
+			continue
+		}
+		for _, in := range m.Inputs {
+			if spends[in] {
+				removed = append(removed, c.removeWithDescendants(id)...)
+				break
+			}
+		}
+	}
+	return removed
+}
+
+// removeWithDescendants drops txid and the mempool transactions spending its
+// outputs, recursively. The caller holds c.mu.
+func (c *Cache) removeWithDescendants(txid string) []string {
+	if _, ok := c.mempool[txid]; !ok {
+		return nil
+	}
+	delete(c.mempool, txid)
+	removed := []string{txid}
+	for id, m := range c.mempool {
+		for _, in := range m.Inputs {
+			if strings.HasPrefix(in, txid+":") {
+				removed = append(removed, c.removeWithDescendants(id)...)
+				break
+			}
+		}
+	}
+	return removed
+}
+
+// trackMempoolTx records tx if it pays a watched address or spends a cached
+// UTXO, confirmed or not, and reports whether it did.
+func trackMempoolTx(cache *Cache, tx *wire.MsgTx) bool {
+	txID := tx.TxHash().String()
+	m := mempoolTx{TxID: txID, Inputs: txInputs(tx)}
+	relevant := false
+	for _, input := range tx.TxIn {
+		prev := input.PreviousOutPoint
+		if address, utxo, ok := cache.findUTXO(prev.Hash.String(), int(prev.Index)); ok {
+			fmt.Printf("Unconfirmed tx %s spends UTXO %s:%d of address %s, Amount: %s\n",
+				txID, utxo.TxID, utxo.Vout, address, utxo.Amount)
+			relevant = true
+		}
+	}
+	for i, output := range tx.TxOut {
+		address, err := extractAddress(output.PkScript)
+		if err != nil || !cache.isWatched(address) {
+			continue
+		}
+		fmt.Printf("Unconfirmed tx %s pays address %s, Vout: %d, Amount: %s\n",
+			txID, address, i, btc.Amount(output.Value))
+		m.Outputs = append(m.Outputs, storedUTXO{Address: address, UTXO: UTXO{
+			TxID:         txID,
+			Vout:         i,
+			Amount:       btc.Amount(output.Value),
+			ScriptPubKey: fmt.Sprintf("addr(%s)", address),
+			Unconfirmed:  true,
+		}})
+		relevant = true
+	}
+	if !relevant {
+		return false
+	}
+	for _, replaced := range cache.addMempoolTx(m) {
+		fmt.Printf("Unconfirmed tx %s was replaced by tx %s\n", replaced, txID)
+	}
+	return true
+}
+
+// mempoolPoller reconciles the tracked mempool transactions with the node's
+// mempool using getrawmempool.
+type mempoolPoller struct {
+	node *rpc.Client
+	// ignored holds the mempool transactions already found irrelevant.
+	ignored map[string]bool
+}
+
+func newMempoolPoller(node *rpc.Client) *mempoolPoller {
+	return &mempoolPoller{node: node, ignored: make(map[string]bool)}
+}
+
+// forgetIgnored makes the next poll look at every mempool transaction again,
+// e.g. after an address was added to the watch list.
+func (p *mempoolPoller) forgetIgnored() {
+	clear(p.ignored)
+}
+
+// poll drops the tracked transactions that left the node's mempool without
+// being confirmed (evicted, expired or replaced) and tracks the relevant new
+// ones.
+func (p *mempoolPoller) poll(ctx context.Context, cache *Cache) error {
+	txids, err := p.node.GetRawMempool(ctx)
+	if err != nil {
+		return fmt.Errorf("getrawmempool failed: %v", err)
+	}
+	// Transactions confirmed by a block that is not processed yet have left
+	// the mempool too; those are reconciled when the block is processed.
+	height, err := p.node.GetBlockCount(ctx)
+	if err != nil {
+		return fmt.Errorf("getblockcount failed: %v", err)
+	}
+	newBlocks := height > cache.getBlockHeight()
+
+	inPool := make(map[string]bool, len(txids))
+	for _, txid := range txids {
+		inPool[txid] = true
+	}
+	for _, txid := range cache.mempoolTxIDs() {
+		if inPool[txid] || newBlocks {
+			continue
+		}
+		for _, dropped := range cache.removeMempoolTx(txid) {
+			fmt.Printf("Unconfirmed tx %s left the mempool without confirming\n", dropped)
+		}
+	}
+	for txid := range p.ignored {
+		if !inPool[txid] {
+			delete(p.ignored, txid)
+		}
+	}
+
+	var fresh []string
+	for _, txid := range txids {
+		if !p.ignored[txid] && !cache.knownTransaction(txid) {
+			fresh = append(fresh, txid)
+		}
+	}
+	var pending []*wire.MsgTx
+	for start := 0; start < len(fresh); start += mempoolBatchSize {
+		txs, err := p.fetch(ctx, fresh[start:min(start+mempoolBatchSize, len(fresh))])
+		if err != nil {
+			return err
+		}
+		pending = append(pending, txs...)
+	}
+
+	// A transaction can spend an unconfirmed output that only becomes
+	// tracked with its parent, which getrawmempool may list later.
+	for len(pending) > 0 {
+		var rest []*wire.MsgTx
+		for _, tx := range pending {
+			if !trackMempoolTx(cache, tx) {
+				rest = append(rest, tx)
+			}
+		}
+		if len(rest) == len(pending) {
+			break
+		}
+		pending = rest
+	}
+	for _, tx := range pending {
+		p.ignored[tx.TxHash().String()] = true
+	}
+	return nil
+}
+
+// fetch gets the raw transactions txids in one batch, leaving out the ones
+// that left the mempool in the meantime.
+func (p *mempoolPoller) fetch(ctx context.Context, txids []string) ([]*wire.MsgTx, error) {
+	batch := p.node.NewBatch()
+	calls := make([]*rpc.BatchCall, len(txids))
+	for i, txid := range txids {
+		calls[i] = batch.Add("getrawtransaction", []interface{}{txid, false}, new(string))
+	}
+	if err := batch.Send(ctx); err != nil {
+		return nil, fmt.Errorf("batched getrawtransaction failed: %v", err)
+	}
+	txs := make([]*wire.MsgTx, 0, len(calls))
+	for i, call := range calls {
+		if call.Err != nil {
+			continue
+		}
+		raw, err := hex.DecodeString(*call.Result.(*string))
+		if err != nil {
+			fmt.Printf("Warning: could not decode mempool transaction %s: %v\n", txids[i], err)
+			continue
+		}
+		tx := new(wire.MsgTx)
+		if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+			fmt.Printf("Warning: could not decode mempool transaction %s: %v\n", txids[i], err)
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
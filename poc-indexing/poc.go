@@ -4,38 +4,73 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 
+	"bitcoin-playground/btc"
+	"bitcoin-playground/config"
+	"bitcoin-playground/network/btcdnet"
+	"bitcoin-playground/rpc"
+
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 )
 
-const (
-	rpcHost = "localhost:48332"
-	rpcUser = "admin"
-	rpcPass = "admin"
-)
-
-var watchedAddresses = []string{
+// defaultWatchedAddresses are watched when neither the config nor the stored
+// index name any address.
+var defaultWatchedAddresses = []string{
 	"muCmmr3fwCvbFbdPUgtw6KFyx92qtDyuyx",
 	"mt7Wd4k9KSs6f7XtAZY96JTsPfxmZLWNMN",
 	"mydBSdJF1fDfe34VJJ5v65cAtrm8w6QBW9",
 }
 
+// activeNetParams are the btcd parameters of the network selected with --network.
+var activeNetParams = &chaincfg.TestNet4Params
+
 // UTXO represents an unspent output.
 type UTXO struct {
-	TxID         string  `json:"txid"`
-	Vout         int     `json:"vout"`
-	Amount       float64 `json:"amount"`
-	ScriptPubKey string  `json:"scriptPubKey"`
-	Height       int     `json:"height"` // Block height where UTXO was confirmed.
-	Desc         string  `json:"desc"`
+	TxID         string     `json:"txid"`
+	Vout         int        `json:"vout"`
+	Amount       btc.Amount `json:"amount"`
+	ScriptPubKey string     `json:"scriptPubKey"`
+	Height       int        `json:"height"` // Block height where UTXO was confirmed.
+	Desc         string     `json:"desc"`
+
+	// Confirmation state, set only on the UTXOs listUTXOs returns.
+	Unconfirmed  bool   `json:"unconfirmed,omitempty"`  // Created by a mempool transaction.
+	PendingSpend string `json:"pendingSpend,omitempty"` // Txid of a mempool transaction spending it.
+}
+
+// state describes the confirmation state of u.
+func (u UTXO) state() string {
+	state := "confirmed"
+	if u.Unconfirmed {
+		state = "unconfirmed"
+	}
+	if u.PendingSpend != "" {
+		state += ", spent by unconfirmed tx " + u.PendingSpend
+	}
+	return state
+}
+
+// SpentUTXO is a formerly unspent output of a watched address and the input
+// that spent it.
+type SpentUTXO struct {
+	UTXO
+	Address     string `json:"address"`
+	SpentBy     string `json:"spentBy"`     // Txid of the spending transaction.
+	SpentHeight int64  `json:"spentHeight"` // Height of the block that spent it.
 }
 
 // Transaction holds basic transaction details.
@@ -46,57 +81,420 @@ type Transaction struct {
 	Outputs   []UTXO   `json:"outputs"`
 }
 
-// Cache stores UTXOs and transactions in memory.
+// Cache stores UTXOs and transactions in memory and writes them through to
+// its Store.
 type Cache struct {
-	utxoMap     map[string][]UTXO // Keyed by address.
+	utxoMap     map[string][]UTXO  // Keyed by address.
+	utxoIndex   map[string]utxoRef // Position of each UTXO in utxoMap, keyed by "txid:vout".
 	txMap       map[string]Transaction
+	spentMap    map[string]SpentUTXO          // Keyed by "txid:vout".
+	blockHashes map[int64]string              // Hash chain of processed blocks, by height.
+	mempool     map[string]mempoolTx          // Unconfirmed transactions of watched addresses; not persisted.
+	watched     map[string]bool               // Watched addresses.
+	descriptors map[string]*watchedDescriptor // Watched descriptors, keyed by their text.
+	derived     map[string]derivedAddress     // Addresses derived from the watched descriptors.
+	gapLimit    uint32
 	blockHeight int64
+	blockHash   string
+	store       Store
 	mu          sync.RWMutex
 }
 
-func newCache() *Cache {
-	return &Cache{
-		utxoMap: make(map[string][]UTXO),
-		txMap:   make(map[string]Transaction),
+// utxoRef locates a UTXO in Cache.utxoMap.
+type utxoRef struct {
+	address string
+	index   int
+}
+
+// newCache returns a cache holding the state already persisted in store. The
+// watched descriptors derive gapLimit addresses past the last one used.
+func newCache(store Store, gapLimit uint32) (*Cache, error) {
+	state, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load stored index: %w", err)
+	}
+	watched := make(map[string]bool, len(state.Watched))
+	for _, address := range state.Watched {
+		watched[address] = true
+	}
+	c := &Cache{
+		utxoMap:     state.UTXOs,
+		txMap:       state.Transactions,
+		spentMap:    state.Spent,
+		blockHashes: state.Blocks,
+		mempool:     make(map[string]mempoolTx),
+		watched:     watched,
+		descriptors: make(map[string]*watchedDescriptor),
+		derived:     make(map[string]derivedAddress),
+		gapLimit:    gapLimit,
+		blockHeight: state.Height,
+		blockHash:   state.Hash,
+		store:       store,
+	}
+	c.indexUTXOs()
+	for text, used := range state.Descriptors {
+		d, err := parseDescriptor(text, activeNetParams)
+		if err != nil {
+			return nil, fmt.Errorf("stored descriptor: %w", err)
+		}
+		c.addDescriptor(d, used)
+	}
+	return c, nil
+}
+
+func (c *Cache) getBlockHeight() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.blockHeight
+}
+
+// setTip records the last processed block.
+func (c *Cache) setTip(changes *Changes, height int64, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blockHeight = height
+	c.blockHash = hash
+	c.blockHashes[height] = hash
+	changes.TipHeight, changes.TipHash = height, hash
+}
+
+// commit writes changes to the store. UTXOs and spent history of addresses
+// unwatched since they were collected are left out.
+func (c *Cache) commit(changes *Changes) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	utxos := changes.UTXOs[:0]
+	for _, u := range changes.UTXOs {
+		if c.isWatchedLocked(u.Address) {
+			utxos = append(utxos, u)
+		}
 	}
+	spent := changes.Spent[:0]
+	for _, sp := range changes.Spent {
+		if c.isWatchedLocked(sp.Address) {
+			spent = append(spent, sp)
+		}
+	}
+	changes.UTXOs, changes.Spent = utxos, spent
+	if err := c.store.Apply(changes); err != nil {
+		fmt.Printf("Warning: could not store the index changes: %v\n", err)
+	}
+}
+
+// getTip returns the last processed block; the hash is empty if none was recorded.
+func (c *Cache) getTip() (int64, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.blockHeight, c.blockHash
+}
+
+// blockHashAt returns the hash of the processed block at height, or "" if
+// that block was not processed.
+func (c *Cache) blockHashAt(height int64) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.blockHashes[height]
 }
 
-func (c *Cache) updateBlockHeight(height int64) {
+// rollback undoes every processed block above height, which must itself be
+// a processed block: the UTXOs and transactions of those blocks are dropped
+// and the UTXOs they spent are unspent again.
+func (c *Cache) rollback(height int64) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if height > c.blockHeight {
-		c.blockHeight = height
+	orphaned := make(map[string]bool)
+	for h, hash := range c.blockHashes {
+		if h > height {
+			orphaned[hash] = true
+			delete(c.blockHashes, h)
+		}
+	}
+	for address, utxos := range c.utxoMap {
+		kept := utxos[:0]
+		for _, u := range utxos {
+			if int64(u.Height) <= height {
+				kept = append(kept, u)
+			}
+		}
+		if len(kept) == 0 {
+			delete(c.utxoMap, address)
+		} else {
+			c.utxoMap[address] = kept
+		}
+	}
+	for key, spent := range c.spentMap {
+		if spent.SpentHeight <= height {
+			continue
+		}
+		delete(c.spentMap, key)
+		if int64(spent.Height) <= height {
+			c.utxoMap[spent.Address] = append(c.utxoMap[spent.Address], spent.UTXO)
+		}
+	}
+	c.indexUTXOs()
+	for txid, tx := range c.txMap {
+		if orphaned[tx.BlockHash] {
+			delete(c.txMap, txid)
+		}
+	}
+	c.blockHeight = height
+	c.blockHash = c.blockHashes[height]
+	if err := c.store.Rollback(height); err != nil {
+		fmt.Printf("Warning: could not roll back the stored index to block %d: %v\n", height, err)
 	}
 }
 
-func (c *Cache) getBlockHeight() int64 {
+// isWatched reports whether address is on the watch list.
+func (c *Cache) isWatched(address string) bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return c.blockHeight
+	return c.isWatchedLocked(address)
+}
+
+// isWatchedLocked is isWatched for callers holding c.mu.
+func (c *Cache) isWatchedLocked(address string) bool {
+	_, derived := c.derived[address]
+	return c.watched[address] || derived
 }
 
-func (c *Cache) addUTXO(address string, utxo UTXO) {
+// watchedAddresses returns the watched addresses, including the ones derived
+// from descriptors, sorted.
+func (c *Cache) watchedAddresses() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	addresses := make([]string, 0, len(c.watched)+len(c.derived))
+	for address := range c.watched {
+		addresses = append(addresses, address)
+	}
+	for address := range c.derived {
+		if !c.watched[address] {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// watch adds address to the watch list and reports whether it was new. The
+// UTXOs it already has are not cached until it is scanned.
+func (c *Cache) watch(address string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.watched[address] {
+		return false
+	}
+	c.watched[address] = true
+	if err := c.store.PutWatched(address); err != nil {
+		fmt.Printf("Warning: could not store watched address %s: %v\n", address, err)
+	}
+	return true
+}
+
+// unwatch removes address from the watch list and drops its UTXOs, confirmed
+// or not, and spent history, unless it is derived from a watched descriptor.
+// It reports whether address was watched.
+func (c *Cache) unwatch(address string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if !c.watched[address] {
+		return false
+	}
+	delete(c.watched, address)
+	_, derived := c.derived[address]
+	if !derived {
+		c.dropAddress(address)
+	}
+	if err := c.store.RemoveWatched(address, !derived); err != nil {
+		fmt.Printf("Warning: could not remove watched address %s from the store: %v\n", address, err)
+	}
+	return true
+}
+
+// dropAddress forgets the UTXOs, confirmed or not, and spent history of
+// address. The caller holds c.mu.
+func (c *Cache) dropAddress(address string) {
+	for _, u := range c.utxoMap[address] {
+		delete(c.utxoIndex, string(outpointKey(u.TxID, u.Vout)))
+	}
+	delete(c.utxoMap, address)
+	for key, spent := range c.spentMap {
+		if spent.Address == address {
+			delete(c.spentMap, key)
+		}
+	}
+	for txid, m := range c.mempool {
+		kept := m.Outputs[:0]
+		for _, out := range m.Outputs {
+			if out.Address != address {
+				kept = append(kept, out)
+			}
+		}
+		m.Outputs = kept
+		c.mempool[txid] = m
+	}
+}
+
+// addUTXO adds utxo unless it is already cached.
+func (c *Cache) addUTXO(changes *Changes, address string, utxo UTXO) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := string(outpointKey(utxo.TxID, utxo.Vout))
+	if _, ok := c.utxoIndex[key]; ok {
+		return
+	}
+	c.utxoIndex[key] = utxoRef{address: address, index: len(c.utxoMap[address])}
 	c.utxoMap[address] = append(c.utxoMap[address], utxo)
+	changes.UTXOs = append(changes.UTXOs, storedUTXO{Address: address, UTXO: utxo})
+	c.markUsed(changes, address)
+}
+
+// spendUTXO moves the cached UTXO txid:vout, if any, to the spent history.
+// It reports whether the outpoint was cached.
+func (c *Cache) spendUTXO(changes *Changes, txid string, vout int, spentBy string, height int64) (SpentUTXO, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := string(outpointKey(txid, vout))
+	ref, ok := c.utxoIndex[key]
+	if !ok {
+		return SpentUTXO{}, false
+	}
+	// Move the last UTXO of the address into the freed slot.
+	utxos := c.utxoMap[ref.address]
+	u, last := utxos[ref.index], utxos[len(utxos)-1]
+	utxos[ref.index] = last
+	c.utxoIndex[string(outpointKey(last.TxID, last.Vout))] = ref
+	delete(c.utxoIndex, key)
+	if len(utxos) == 1 {
+		delete(c.utxoMap, ref.address)
+	} else {
+		c.utxoMap[ref.address] = utxos[:len(utxos)-1]
+	}
+	spent := SpentUTXO{UTXO: u, Address: ref.address, SpentBy: spentBy, SpentHeight: height}
+	c.spentMap[key] = spent
+	changes.Spent = append(changes.Spent, spent)
+	return spent, true
+}
+
+// indexUTXOs rebuilds utxoIndex from utxoMap. The caller holds c.mu, or has
+// the only reference to c.
+func (c *Cache) indexUTXOs() {
+	c.utxoIndex = make(map[string]utxoRef)
+	for address, utxos := range c.utxoMap {
+		for i, u := range utxos {
+			c.utxoIndex[string(outpointKey(u.TxID, u.Vout))] = utxoRef{address: address, index: i}
+		}
+	}
+}
+
+// findUTXO returns the cached UTXO txid:vout, confirmed or not, and the
+// address it pays.
+func (c *Cache) findUTXO(txid string, vout int) (string, UTXO, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if ref, ok := c.utxoIndex[string(outpointKey(txid, vout))]; ok {
+		return ref.address, c.utxoMap[ref.address][ref.index], true
+	}
+	for _, out := range c.mempool[txid].Outputs {
+		if out.Vout == vout {
+			return out.Address, out.UTXO, true
+		}
+	}
+	return "", UTXO{}, false
 }
 
-func (c *Cache) addTransaction(tx Transaction) {
+// knownTransaction reports whether txid is cached, confirmed or not.
+func (c *Cache) knownTransaction(txid string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, confirmed := c.txMap[txid]
+	_, unconfirmed := c.mempool[txid]
+	return confirmed || unconfirmed
+}
+
+// addTransaction caches tx, a transaction of a watched address.
+func (c *Cache) addTransaction(changes *Changes, tx Transaction) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.txMap[tx.TxID] = tx
+	changes.Transactions = append(changes.Transactions, tx)
 }
 
+// listUTXOs returns the confirmed UTXOs and those created by mempool
+// transactions, with their confirmation state.
 func (c *Cache) listUTXOs() []UTXO {
+	return c.addressUTXOs("")
+}
+
+// addressUTXOs is listUTXOs limited to the UTXOs of address; an empty address
+// matches all of them.
+func (c *Cache) addressUTXOs(address string) []UTXO {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	pendingSpends := make(map[string]string)
+	for _, m := range c.mempool {
+		for _, in := range m.Inputs {
+			pendingSpends[in] = m.TxID
+		}
+	}
 	var utxos []UTXO
-	for _, addrUTXOs := range c.utxoMap {
-		utxos = append(utxos, addrUTXOs...)
+	add := func(u UTXO) {
+		u.PendingSpend = pendingSpends[string(outpointKey(u.TxID, u.Vout))]
+		utxos = append(utxos, u)
+	}
+	for addr, addrUTXOs := range c.utxoMap {
+		if address != "" && addr != address {
+			continue
+		}
+		for _, u := range addrUTXOs {
+			add(u)
+		}
+	}
+	for _, m := range c.mempool {
+		for _, out := range m.Outputs {
+			if address == "" || out.Address == address {
+				add(out.UTXO)
+			}
+		}
 	}
 	return utxos
 }
 
+// findTransaction returns the cached transaction txid and reports whether it
+// is confirmed; unconfirmed ones only list the outputs of watched addresses.
+func (c *Cache) findTransaction(txid string) (Transaction, bool, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if tx, ok := c.txMap[txid]; ok {
+		return tx, true, true
+	}
+	m, ok := c.mempool[txid]
+	if !ok {
+		return Transaction{}, false, false
+	}
+	tx := Transaction{TxID: txid, Inputs: m.Inputs}
+	for _, out := range m.Outputs {
+		tx.Outputs = append(tx.Outputs, out.UTXO)
+	}
+	return tx, false, true
+}
+
+// listSpent returns the spent history, oldest first.
+func (c *Cache) listSpent() []SpentUTXO {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	spent := make([]SpentUTXO, 0, len(c.spentMap))
+	for _, s := range c.spentMap {
+		spent = append(spent, s)
+	}
+	sort.Slice(spent, func(i, j int) bool {
+		if spent[i].SpentHeight != spent[j].SpentHeight {
+			return spent[i].SpentHeight < spent[j].SpentHeight
+		}
+		return spent[i].SpentBy < spent[j].SpentBy
+	})
+	return spent
+}
+
 func (c *Cache) listTransactions() []Transaction {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -108,61 +506,208 @@ func (c *Cache) listTransactions() []Transaction {
 }
 
 func main() {
-	client, err := connectRPC()
+	dbPath := flag.String("db", "indexer.db", "bbolt file the index is kept in across restarts; empty keeps it in memory only")
+	fetchers := flag.Int("fetch-concurrency", 4, "blocks fetched concurrently while catching up with the node")
+	maxReorgDepth := flag.Int64("max-reorg-depth", 100, "deepest chain reorganization, in blocks, that is rolled back automatically")
+	trackMempool := flag.Bool("mempool", true, "track unconfirmed transactions of the watched addresses by polling getrawmempool")
+	zmqBlock := flag.String("zmq-block", "", "ZMQ endpoint publishing hashblock (rawblock is not used), e.g. tcp://127.0.0.1:28332; new blocks are then processed as soon as they arrive")
+	zmqTx := flag.String("zmq-tx", "", "ZMQ endpoint publishing rawtx, used to pick up unconfirmed transactions of the watched addresses")
+	watchList := flag.String("watch", "", "comma-separated addresses and output descriptors to watch in addition to the config's watch list and the stored ones")
+	apiAddr := flag.String("http", "", "listen address of the HTTP API, e.g. 127.0.0.1:8080; empty disables it")
+	gapLimit := flag.Uint("gap-limit", 20, "addresses derived from each watched descriptor chain past the last one used")
+	reportPath := flag.String("report", "", "JSON file the final report is exported to on shutdown")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Printf("Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	client, err := btcdnet.Connect(cfg.Node)
 	if err != nil {
 		fmt.Printf("Failed to connect to Bitcoin RPC: %v\n", err)
 		os.Exit(1)
 	}
-	defer client.Shutdown()
 
-	fmt.Println("Starting Bitcoin UTXO Indexer...")
-	cache := newCache()
+	params, err := cfg.NetParams()
+	if err != nil {
+		fmt.Printf("Invalid network: %v\n", err)
+		os.Exit(1)
+	}
+	if err := btcdnet.CheckNetwork(client, params); err != nil {
+		fmt.Printf("Network mismatch: %v\n", err)
+		os.Exit(1)
+	}
+	activeNetParams = btcdnet.ChainParams(params)
 
-	// Run a full UTXO scan.
-	if err := startFullScan(client, cache); err != nil {
-		fmt.Printf("Failed to perform full UTXO scan: %v\n", err)
+	// The batch-capable client is used where many calls can share a round trip.
+	node, err := rpc.NewEndpointClient(cfg.Node)
+	if err != nil {
+		fmt.Printf("Failed to create batch RPC client: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Start background monitoring for new blocks.
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go continuousUTXOMonitor(ctx, client, cache)
+	fmt.Println("Starting Bitcoin UTXO Indexer...")
+	store, err := openStore(*dbPath)
+	if err != nil {
+		fmt.Printf("Failed to open the index store: %v\n", err)
+		os.Exit(1)
+	}
+	cache, err := newCache(store, uint32(*gapLimit))
+	if err != nil {
+		fmt.Printf("Failed to load the index: %v\n", err)
+		os.Exit(1)
+	}
 
-	// On exit, print the cached UTXOs and transactions.
-	defer func() {
-		fmt.Println("UTXOs:")
-		for _, u := range cache.listUTXOs() {
-			fmt.Printf("TxID: %s, Amount: %.8f\n", u.TxID, u.Amount)
+	entries := append(cfg.Watch, splitWatchList(*watchList)...)
+	if len(entries) == 0 && len(cache.watchedAddresses()) == 0 {
+		entries = defaultWatchedAddresses
+	}
+	var added []string
+	for _, entry := range entries {
+		addresses, err := watchEntry(cache, params, entry)
+		if err != nil {
+			fmt.Printf("Invalid watch list entry: %v\n", err)
+			os.Exit(1)
 		}
-		fmt.Println("Transactions:")
-		for _, t := range cache.listTransactions() {
-			fmt.Printf("TxID: %s, BlockHash: %s\n", t.TxID, t.BlockHash)
+		added = append(added, addresses...)
+	}
+
+	// Resume from the stored tip, scanning the addresses watched since, or
+	// run a full UTXO scan on first start.
+	if height, hash := cache.getTip(); hash != "" {
+		fmt.Printf("Resuming from stored block %d (%s)\n", height, hash)
+		if len(added) > 0 {
+			if _, _, err := scanAddresses(client, node, cache, added); err != nil {
+				fmt.Printf("Failed to scan the newly watched addresses: %v\n", err)
+				os.Exit(1)
+			}
 		}
+	} else if err := startFullScan(client, node, cache); err != nil {
+		fmt.Printf("Failed to perform full UTXO scan: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Start background monitoring for new blocks, until SIGINT or SIGTERM.
+	// Before this point a signal exits right away, which is safe as the
+	// changes of a block or scan are stored in a single transaction.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var mempool *mempoolPoller
+	if *trackMempool {
+		mempool = newMempoolPoller(node)
+	}
+	newBlock := make(chan struct{}, 1)
+	startZMQ(ctx, *zmqBlock, *zmqTx, cache, newBlock)
+	rescan := make(chan []string, 16)
+	go readWatchCommands(os.Stdin, cache, params, rescan)
+	monitorDone := make(chan struct{})
+	go func() {
+		defer close(monitorDone)
+		continuousUTXOMonitor(ctx, client, cache, monitorConfig{
+			MaxReorgDepth: *maxReorgDepth,
+			Fetchers:      *fetchers,
+			NewBlock:      newBlock,
+			Rescan:        rescan,
+			Node:          node,
+			Mempool:       mempool,
+		})
 	}()
 
-	// Block forever.
-	select {}
+	var api *http.Server
+	if *apiAddr != "" {
+		if api, err = startAPI(*apiAddr, cache, node); err != nil {
+			fmt.Printf("Failed to start the HTTP API: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	<-ctx.Done()
+	stop() // A second signal terminates right away.
+	fmt.Println("Shutting down...")
+	shutdown(api, monitorDone, client, store)
+
+	printReport(cache)
+	if *reportPath != "" {
+		if err := exportReport(cache, *reportPath); err != nil {
+			fmt.Printf("Failed to export the report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Report written to %s\n", *reportPath)
+	}
 }
 
-// connectRPC connects to the bitcoind RPC.
-func connectRPC() (*rpcclient.Client, error) {
-	connCfg := &rpcclient.ConnConfig{
-		Host:         rpcHost,
-		User:         rpcUser,
-		Pass:         rpcPass,
-		HTTPPostMode: true,
-		DisableTLS:   true,
+// shutdownTimeout bounds each step of the shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// shutdown stops the HTTP API, waits for the monitor to finish the block it is
+// processing, then disconnects from the node and closes the store.
+func shutdown(api *http.Server, monitorDone <-chan struct{}, client *rpcclient.Client, store Store) {
+	if api != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := api.Shutdown(ctx); err != nil {
+			fmt.Printf("Warning: HTTP API did not shut down cleanly: %v\n", err)
+		}
+		cancel()
+	}
+	select {
+	case <-monitorDone:
+	case <-time.After(shutdownTimeout):
+		fmt.Printf("Warning: block processing did not stop within %s\n", shutdownTimeout)
+	}
+	client.Shutdown()
+	client.WaitForShutdown()
+	if err := store.Close(); err != nil {
+		fmt.Printf("Warning: could not close the index store: %v\n", err)
 	}
-	return rpcclient.New(connCfg, nil)
 }
 
-// startFullScan performs a full UTXO scan using scantxoutset.
-func startFullScan(client *rpcclient.Client, cache *Cache) error {
+// startFullScan performs a full UTXO scan of the watched addresses using
+// scantxoutset and makes the scanned block the tip.
+func startFullScan(client *rpcclient.Client, node *rpc.Client, cache *Cache) error {
 	fmt.Println("Performing full blockchain UTXO scan...")
+	height, bestBlock, err := scanAddresses(client, node, cache, cache.watchedAddresses())
+	if err != nil {
+		return err
+	}
+	var changes Changes
+	cache.setTip(&changes, height, bestBlock)
+	cache.commit(&changes)
+	return nil
+}
+
+// scanAddresses caches the current UTXOs of addresses, and their
+// transactions, using scantxoutset. UTXOs of addresses derived from a
+// descriptor extend its derivation window, and the addresses derived in turn
+// are scanned as well. It returns the block the first scan ran at.
+func scanAddresses(client *rpcclient.Client, node *rpc.Client, cache *Cache, addresses []string) (int64, string, error) {
+	height, bestBlock := int64(-1), ""
+	for len(addresses) > 0 {
+		known := make(map[string]bool)
+		for _, address := range cache.watchedAddresses() {
+			known[address] = true
+		}
+		h, hash, err := scanUTXOs(client, node, cache, addresses)
+		if err != nil {
+			return 0, "", err
+		}
+		if height < 0 {
+			height, bestBlock = h, hash
+		}
+		addresses = nil
+		for _, address := range cache.watchedAddresses() {
+			if !known[address] {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return height, bestBlock, nil
+}
 
+// scanUTXOs runs a single scantxoutset for addresses and caches the UTXOs
+// found and their transactions. It returns the block the scan ran at.
+func scanUTXOs(client *rpcclient.Client, node *rpc.Client, cache *Cache, addresses []string) (int64, string, error) {
 	var scanObjects []map[string]interface{}
-	for _, addr := range watchedAddresses {
+	for _, addr := range addresses {
 		scanObjects = append(scanObjects, map[string]interface{}{
 			"desc": fmt.Sprintf("addr(%s)", addr),
 		})
@@ -173,21 +718,27 @@ func startFullScan(client *rpcclient.Client, cache *Cache) error {
 		json.RawMessage(marshalJSON(scanObjects)),
 	})
 	if err != nil {
-		return fmt.Errorf("scantxoutset failed: %v", err)
+		return 0, "", fmt.Errorf("scantxoutset failed: %v", err)
 	}
 
 	var result struct {
-		Unspents  []UTXO  `json:"unspents"`
-		Success   bool    `json:"success"`
-		TXOuts    int     `json:"txouts"`
-		Height    int64   `json:"height"`
-		BestBlock string  `json:"bestblock"`
-		TotalAmt  float64 `json:"total_amount"`
+		Unspents  []UTXO     `json:"unspents"`
+		Success   bool       `json:"success"`
+		TXOuts    int        `json:"txouts"`
+		Height    int64      `json:"height"`
+		BestBlock string     `json:"bestblock"`
+		TotalAmt  btc.Amount `json:"total_amount"`
 	}
 	if err := json.Unmarshal(rawResult, &result); err != nil {
-		return fmt.Errorf("failed to parse scantxoutset result: %v", err)
+		return 0, "", fmt.Errorf("failed to parse scantxoutset result: %v", err)
 	}
 
+	// Fetch the transactions of all UTXOs in two batched round trips.
+	txs := fetchTransactions(context.Background(), node, result.Unspents)
+
+	var changes Changes
+	defer cache.commit(&changes)
+
 	for _, utxo := range result.Unspents {
 		scriptBytes, err := hex.DecodeString(utxo.ScriptPubKey)
 		if err != nil {
@@ -200,21 +751,71 @@ func startFullScan(client *rpcclient.Client, cache *Cache) error {
 			continue
 		}
 
-		fmt.Printf("Found UTXO for address %s, Amount: %f\n", addr, utxo.Amount)
-		cache.addUTXO(addr, utxo)
+		if !cache.isWatched(addr) {
+			continue // Removed from the watch list during the scan.
+		}
+		fmt.Printf("Found UTXO for address %s, Amount: %s\n", addr, utxo.Amount)
+		cache.addUTXO(&changes, addr, utxo)
+
+		if tx, ok := txs[utxo.TxID]; ok {
+			cache.addTransaction(&changes, tx)
+			continue
+		}
 
-		// Retrieve transaction details for the UTXO.
-		tx, txHeight, err := getTransactionDetails(client, utxo.TxID, utxo.Vout, utxo.Height)
+		// Fall back to retrieving the transaction details one by one.
+		tx, _, err := getTransactionDetails(client, utxo.TxID, utxo.Vout, utxo.Height)
 		if err != nil {
 			fmt.Printf("Warning: could not get transaction details for %s: %v\n", utxo.TxID, err)
 			continue
 		}
-		cache.updateBlockHeight(txHeight)
-		cache.addTransaction(tx)
+		cache.addTransaction(&changes, tx)
 	}
 
-	fmt.Printf("Indexed %d UTXOs from full scan\n", len(result.Unspents))
-	return nil
+	fmt.Printf("Indexed %d UTXOs from scan at block %d\n", len(result.Unspents), result.Height)
+	return result.Height, result.BestBlock, nil
+}
+
+// fetchTransactions looks up the block hash at each UTXO's confirmation height
+// and then the transactions themselves, each step as a single JSON-RPC batch.
+// Transactions that could not be fetched are left out of the result.
+func fetchTransactions(ctx context.Context, node *rpc.Client, utxos []UTXO) map[string]Transaction {
+	hashBatch := node.NewBatch()
+	blockHashes := make(map[int]*string)
+	for _, utxo := range utxos {
+		if _, ok := blockHashes[utxo.Height]; !ok {
+			blockHashes[utxo.Height] = new(string)
+			hashBatch.Add("getblockhash", []interface{}{utxo.Height}, blockHashes[utxo.Height])
+		}
+	}
+	if err := hashBatch.Send(ctx); err != nil {
+		fmt.Printf("Warning: batched getblockhash failed: %v\n", err)
+		return nil
+	}
+
+	txBatch := node.NewBatch()
+	calls := make(map[string]*rpc.BatchCall)
+	for _, utxo := range utxos {
+		blockHash := *blockHashes[utxo.Height]
+		if _, ok := calls[utxo.TxID]; ok || blockHash == "" {
+			continue
+		}
+		calls[utxo.TxID] = txBatch.Add("getrawtransaction", []interface{}{utxo.TxID, true, blockHash}, new(rpc.RawTransaction))
+	}
+	if err := txBatch.Send(ctx); err != nil {
+		fmt.Printf("Warning: batched getrawtransaction failed: %v\n", err)
+		return nil
+	}
+
+	txs := make(map[string]Transaction, len(calls))
+	for txid, call := range calls {
+		if call.Err != nil {
+			fmt.Printf("Warning: batched lookup of %s failed: %v\n", txid, call.Err)
+			continue
+		}
+		raw := call.Result.(*rpc.RawTransaction)
+		txs[txid] = Transaction{TxID: raw.TxID, BlockHash: raw.BlockHash}
+	}
+	return txs
 }
 
 // getTransactionDetails tries to retrieve transaction details; if the direct call fails,
@@ -303,7 +904,7 @@ func extractTransactionDetails(client *rpcclient.Client, txVerbose *btcjson.TxRa
 
 // extractAddress extracts a Bitcoin address from a PkScript.
 func extractAddress(pkScript []byte) (string, error) {
-	_, addresses, _, err := txscript.ExtractPkScriptAddrs(pkScript, &chaincfg.TestNet3Params)
+	_, addresses, _, err := txscript.ExtractPkScriptAddrs(pkScript, activeNetParams)
 	if err != nil || len(addresses) == 0 {
 		return "", fmt.Errorf("failed to extract address: %v", err)
 	}
@@ -320,8 +921,27 @@ func marshalJSON(v interface{}) string {
 	return string(bytes)
 }
 
-// continuousUTXOMonitor polls for new blocks and processes them.
-func continuousUTXOMonitor(ctx context.Context, client *rpcclient.Client, cache *Cache) {
+// monitorConfig holds the settings of continuousUTXOMonitor.
+type monitorConfig struct {
+	// MaxReorgDepth is the deepest reorganization rolled back automatically.
+	MaxReorgDepth int64
+	// Fetchers is the number of blocks fetched concurrently when catching up.
+	Fetchers int
+	// NewBlock, if not nil, signals new blocks ahead of the next poll.
+	NewBlock <-chan struct{}
+	// Rescan receives newly watched addresses, whose UTXOs are scanned for
+	// with Node before the next poll.
+	Rescan <-chan []string
+	Node   *rpc.Client
+	// Mempool, if not nil, reconciles the unconfirmed transactions after
+	// every poll.
+	Mempool *mempoolPoller
+}
+
+// continuousUTXOMonitor polls for new blocks, and checks right away when
+// cfg.NewBlock signals one or an address is added to the watch list. It processes every block since the last processed
+// one and rolls back the blocks a reorganization orphaned.
+func continuousUTXOMonitor(ctx context.Context, client *rpcclient.Client, cache *Cache, cfg monitorConfig) {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
@@ -330,69 +950,140 @@ func continuousUTXOMonitor(ctx context.Context, client *rpcclient.Client, cache
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			currentBlock, err := client.GetBlockCount()
-			if err != nil {
-				fmt.Printf("Failed to get block count: %v\n", err)
+		case <-cfg.NewBlock:
+		case addresses := <-cfg.Rescan:
+			if _, _, err := scanAddresses(client, cfg.Node, cache, addresses); err != nil {
+				fmt.Printf("Failed to scan newly watched addresses: %v\n", err)
+			}
+			if cfg.Mempool != nil {
+				cfg.Mempool.forgetIgnored()
+			}
+		}
+
+		currentBlock, err := client.GetBlockCount()
+		if err != nil {
+			fmt.Printf("Failed to get block count: %v\n", err)
+			continue
+		}
+		if err := handleReorg(client, cache, currentBlock, cfg.MaxReorgDepth); err != nil {
+			fmt.Printf("Failed to handle chain reorganization: %v\n", err)
+			continue
+		}
+		lastBlock := cache.getBlockHeight()
+		if currentBlock > lastBlock {
+			fmt.Printf("New block detected: %d -> %d, updating UTXOs...\n", lastBlock, currentBlock)
+			if err := syncBlocks(ctx, client, cfg.Node, cache, lastBlock+1, currentBlock, cfg.Fetchers); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				fmt.Printf("Failed to process blocks: %v\n", err)
 				continue
 			}
-			lastBlock := cache.getBlockHeight()
-			if currentBlock > lastBlock {
-				fmt.Printf("New block detected: %d -> %d, updating UTXOs...\n", lastBlock, currentBlock)
-				processNewBlock(client, currentBlock, cache)
-				cache.updateBlockHeight(currentBlock)
+		}
+		if cfg.Mempool != nil {
+			if err := cfg.Mempool.poll(ctx, cache); err != nil && ctx.Err() == nil {
+				fmt.Printf("Failed to poll the mempool: %v\n", err)
 			}
 		}
 	}
 }
 
-// processNewBlock processes a new block and caches UTXOs for watched addresses.
-func processNewBlock(client *rpcclient.Client, blockHeight int64, cache *Cache) {
-	blockHash, err := client.GetBlockHash(blockHeight)
+// handleReorg checks that the last processed block is still on the node's
+// active chain. If it is not, the index is rolled back to the fork point, so
+// that the caller processes the new branch from there.
+func handleReorg(client *rpcclient.Client, cache *Cache, nodeHeight, maxDepth int64) error {
+	tipHeight, tipHash := cache.getTip()
+	if tipHash == "" {
+		return nil
+	}
+	fork, err := findForkPoint(client, cache, tipHeight, nodeHeight, maxDepth)
 	if err != nil {
-		fmt.Printf("Failed to get block hash: %v\n", err)
-		return
+		return err
+	}
+	if fork == tipHeight {
+		return nil
 	}
 
-	block, err := client.GetBlock(blockHash)
-	if err != nil {
-		fmt.Printf("Failed to get block %s: %v\n", blockHash, err)
-		return
+	fmt.Printf("Chain reorganization detected: blocks %d-%d were orphaned, rolling back to block %d\n", fork+1, tipHeight, fork)
+	cache.rollback(fork)
+	return nil
+}
+
+// findForkPoint returns the highest processed block, at most maxDepth blocks
+// below tipHeight, that is still on the node's active chain. Heights that
+// were never processed are skipped.
+func findForkPoint(client *rpcclient.Client, cache *Cache, tipHeight, nodeHeight, maxDepth int64) (int64, error) {
+	for height := tipHeight; height >= 0 && height >= tipHeight-maxDepth; height-- {
+		stored := cache.blockHashAt(height)
+		if stored == "" || height > nodeHeight {
+			continue
+		}
+		hash, err := client.GetBlockHash(height)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get block hash at height %d: %v", height, err)
+		}
+		if hash.String() == stored {
+			return height, nil
+		}
 	}
+	return 0, fmt.Errorf("no processed block within %d blocks of %d is on the active chain; remove the index to rescan", maxDepth, tipHeight)
+}
 
+// processNewBlock caches the UTXOs a block creates for watched addresses, the
+// ones it spends and the transactions involved, and makes it the tip. All of
+// it is stored in a single transaction.
+func processNewBlock(cache *Cache, blockHeight int64, blockHash *chainhash.Hash, block *wire.MsgBlock) {
+	var changes Changes
 	for _, tx := range block.Transactions {
 		txID := tx.TxHash().String()
-		cache.addTransaction(Transaction{
-			TxID:      txID,
-			BlockHash: blockHash.String(),
-		})
+		tracked, dropped := cache.confirmMempoolTx(txID, txInputs(tx))
+		if tracked {
+			fmt.Printf("Unconfirmed tx %s confirmed in block %d\n", txID, blockHeight)
+		}
+		for _, d := range dropped {
+			fmt.Printf("Unconfirmed tx %s dropped, tx %s in block %d double spends it\n", d, txID, blockHeight)
+		}
+
+		// Inputs first: a transaction never spends its own outputs, but it
+		// may spend outputs of earlier transactions in the same block.
+		relevant := tracked
+		for _, input := range tx.TxIn {
+			prev := input.PreviousOutPoint
+			if spent, ok := cache.spendUTXO(&changes, prev.Hash.String(), int(prev.Index), txID, blockHeight); ok {
+				fmt.Printf("UTXO %s:%d of address %s spent by tx %s, Amount: %s\n",
+					spent.TxID, spent.Vout, spent.Address, txID, spent.Amount)
+				relevant = true
+			}
+		}
 
 		for i, output := range tx.TxOut {
 			address, err := extractAddress(output.PkScript)
 			if err != nil || address == "" {
 				continue
 			}
-			if isWatchedAddress(address) {
-				fmt.Printf("Found UTXO for address %s in tx %s, Vout: %d, Amount: %.8f\n",
-					address, txID, i, float64(output.Value)/100000000)
+			if cache.isWatched(address) {
+				fmt.Printf("Found UTXO for address %s in tx %s, Vout: %d, Amount: %s\n",
+					address, txID, i, btc.Amount(output.Value))
 				utxo := UTXO{
 					TxID:         txID,
 					Vout:         i,
-					Amount:       float64(output.Value) / 100000000,
+					Amount:       btc.Amount(output.Value),
 					ScriptPubKey: fmt.Sprintf("addr(%s)", address),
+					Height:       int(blockHeight),
 				}
-				cache.addUTXO(address, utxo)
+				cache.addUTXO(&changes, address, utxo)
+				relevant = true
 			}
 		}
-	}
-	fmt.Printf("Indexed UTXOs from block %d\n", blockHeight)
-}
 
-// isWatchedAddress checks if an address is in the watched list.
-func isWatchedAddress(address string) bool {
-	for _, addr := range watchedAddresses {
-		if addr == address {
-			return true
+		// Only transactions of the watched addresses are kept.
+		if relevant {
+			cache.addTransaction(&changes, Transaction{
+				TxID:      txID,
+				BlockHash: blockHash.String(),
+			})
 		}
 	}
-	return false
+	cache.setTip(&changes, blockHeight, blockHash.String())
+	cache.commit(&changes)
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// report is the final state of the index, exported as JSON on shutdown.
+type report struct {
+	Height       int64         `json:"height"`
+	Hash         string        `json:"hash"`
+	UTXOs        []UTXO        `json:"utxos"`
+	Spent        []SpentUTXO   `json:"spent"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// printReport prints the cached UTXOs, spent history and transactions.
+func printReport(cache *Cache) {
+	fmt.Println("UTXOs:")
+	for _, u := range cache.listUTXOs() {
+		fmt.Printf("TxID: %s, Vout: %d, Amount: %s, State: %s\n", u.TxID, u.Vout, u.Amount, u.state())
+	}
+	fmt.Println("Spent UTXOs:")
+	for _, s := range cache.listSpent() {
+		fmt.Printf("TxID: %s, Vout: %d, Amount: %s, SpentBy: %s, Height: %d\n", s.TxID, s.Vout, s.Amount, s.SpentBy, s.SpentHeight)
+	}
+	fmt.Println("Transactions:")
+	for _, t := range cache.listTransactions() {
+		fmt.Printf("TxID: %s, BlockHash: %s\n", t.TxID, t.BlockHash)
+	}
+}
+
+// exportReport writes the report to path.
+func exportReport(cache *Cache, path string) error {
+	r := report{
+		UTXOs:        cache.listUTXOs(),
+		Spent:        cache.listSpent(),
+		Transactions: cache.listTransactions(),
+	}
+	r.Height, r.Hash = cache.getTip()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
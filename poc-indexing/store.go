@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store persists the indexer state so that it survives restarts.
+type Store interface {
+	// Load returns everything stored so far. An empty store has no tip hash.
+	Load() (*State, error)
+	// Apply makes all of changes at once, or none of them.
+	Apply(changes *Changes) error
+	// Rollback undoes every block above height: it drops the UTXOs and
+	// transactions they added, restores the UTXOs they spent and makes
+	// height the tip.
+	Rollback(height int64) error
+	// PutWatched adds an address to the watch list.
+	PutWatched(address string) error
+	// RemoveWatched removes an address from the watch list. Its UTXOs and
+	// spent history are removed as well if drop is set; they are kept for
+	// addresses still derived from a watched descriptor.
+	RemoveWatched(address string, drop bool) error
+	// PutDescriptor adds a descriptor to the watch list or updates it. used
+	// holds, per chain, one past the highest index found paid.
+	PutDescriptor(desc string, used []uint32) error
+	// RemoveDescriptor removes a descriptor from the watch list, together
+	// with the UTXOs and spent history of addresses, the ones derived from it.
+	RemoveDescriptor(desc string, addresses []string) error
+	Close() error
+}
+
+// Changes are the writes of a block or a scan, which a Store applies
+// together so that a crash never leaves a block half stored.
+type Changes struct {
+	UTXOs        []storedUTXO
+	Spent        []SpentUTXO // Removed from the UTXOs.
+	Transactions []Transaction
+	Descriptors  map[string][]uint32 // Used indexes per chain, keyed by descriptor.
+	// TipHash, if not empty, makes TipHeight the last processed block and
+	// adds it to the hash chain.
+	TipHeight int64
+	TipHash   string
+}
+
+// State is the content of a Store.
+type State struct {
+	UTXOs        map[string][]UTXO // Keyed by address.
+	Transactions map[string]Transaction
+	Spent        map[string]SpentUTXO // Keyed by "txid:vout".
+	Blocks       map[int64]string     // Hash chain of processed blocks, by height.
+	Watched      []string
+	Descriptors  map[string][]uint32 // Used indexes per chain, keyed by descriptor.
+	Height       int64
+	Hash         string
+}
+
+func newState() *State {
+	return &State{
+		UTXOs:        make(map[string][]UTXO),
+		Transactions: make(map[string]Transaction),
+		Spent:        make(map[string]SpentUTXO),
+		Blocks:       make(map[int64]string),
+		Descriptors:  make(map[string][]uint32),
+	}
+}
+
+// memoryStore keeps nothing; the Cache alone holds the state.
+type memoryStore struct{}
+
+func (memoryStore) Load() (*State, error)                   { return newState(), nil }
+func (memoryStore) Apply(*Changes) error                    { return nil }
+func (memoryStore) Rollback(height int64) error             { return nil }
+func (memoryStore) PutWatched(string) error                 { return nil }
+func (memoryStore) RemoveWatched(string, bool) error        { return nil }
+func (memoryStore) PutDescriptor(string, []uint32) error    { return nil }
+func (memoryStore) RemoveDescriptor(string, []string) error { return nil }
+func (memoryStore) Close() error                            { return nil }
+
+// Buckets and keys of the bbolt file.
+var (
+	utxoBucket   = []byte("utxos")        // "txid:vout" -> storedUTXO
+	spentBucket  = []byte("spent")        // "txid:vout" -> SpentUTXO
+	txBucket     = []byte("transactions") // txid -> Transaction
+	blockBucket  = []byte("blocks")       // height -> block hash
+	watchBucket  = []byte("watched")      // address -> nothing
+	descBucket   = []byte("descriptors")  // descriptor -> used indexes per chain
+	metaBucket   = []byte("meta")
+	tipHeightKey = []byte("height")
+	tipHashKey   = []byte("hash")
+)
+
+// storedUTXO is a UTXO together with the watched address it pays.
+type storedUTXO struct {
+	Address string `json:"address"`
+	UTXO
+}
+
+// boltStore is a Store backed by a bbolt file.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// openStore opens the bbolt file at path, or a memory-only store if path is empty.
+func openStore(path string) (Store, error) {
+	if path == "" {
+		return memoryStore{}, nil
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{utxoBucket, spentBucket, txBucket, blockBucket, watchBucket, descBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Load() (*State, error) {
+	state := newState()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(utxoBucket).ForEach(func(_, v []byte) error {
+			var u storedUTXO
+			if err := json.Unmarshal(v, &u); err != nil {
+				return err
+			}
+			state.UTXOs[u.Address] = append(state.UTXOs[u.Address], u.UTXO)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(spentBucket).ForEach(func(k, v []byte) error {
+			var spent SpentUTXO
+			if err := json.Unmarshal(v, &spent); err != nil {
+				return err
+			}
+			state.Spent[string(k)] = spent
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(txBucket).ForEach(func(k, v []byte) error {
+			var t Transaction
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			state.Transactions[string(k)] = t
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(blockBucket).ForEach(func(k, v []byte) error {
+			state.Blocks[int64(binary.BigEndian.Uint64(k))] = string(v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(watchBucket).ForEach(func(k, _ []byte) error {
+			state.Watched = append(state.Watched, string(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(descBucket).ForEach(func(k, v []byte) error {
+			var used []uint32
+			if err := json.Unmarshal(v, &used); err != nil {
+				return err
+			}
+			state.Descriptors[string(k)] = used
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		meta := tx.Bucket(metaBucket)
+		if h := meta.Get(tipHeightKey); len(h) == 8 {
+			state.Height = int64(binary.BigEndian.Uint64(h))
+		}
+		state.Hash = string(meta.Get(tipHashKey))
+		// Files written before the hash chain was kept only have the tip.
+		if _, ok := state.Blocks[state.Height]; !ok && state.Hash != "" {
+			state.Blocks[state.Height] = state.Hash
+		}
+		return nil
+	})
+	return state, err
+}
+
+func (s *boltStore) Apply(changes *Changes) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		utxos, spent := tx.Bucket(utxoBucket), tx.Bucket(spentBucket)
+		for _, u := range changes.UTXOs {
+			v, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+			if err := utxos.Put(outpointKey(u.TxID, u.Vout), v); err != nil {
+				return err
+			}
+		}
+		for _, sp := range changes.Spent {
+			v, err := json.Marshal(sp)
+			if err != nil {
+				return err
+			}
+			key := outpointKey(sp.TxID, sp.Vout)
+			if err := utxos.Delete(key); err != nil {
+				return err
+			}
+			if err := spent.Put(key, v); err != nil {
+				return err
+			}
+		}
+		for _, t := range changes.Transactions {
+			v, err := json.Marshal(t)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(txBucket).Put([]byte(t.TxID), v); err != nil {
+				return err
+			}
+		}
+		for desc, used := range changes.Descriptors {
+			if err := putDescriptor(tx, desc, used); err != nil {
+				return err
+			}
+		}
+		if changes.TipHash == "" {
+			return nil
+		}
+		if err := tx.Bucket(blockBucket).Put(heightKey(changes.TipHeight), []byte(changes.TipHash)); err != nil {
+			return err
+		}
+		return putTip(tx, changes.TipHeight, changes.TipHash)
+	})
+}
+
+func (s *boltStore) Rollback(height int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		// Forget the orphaned blocks, remembering their hashes.
+		orphaned := make(map[string]bool)
+		err := deleteWhere(tx.Bucket(blockBucket), func(k, v []byte) (bool, error) {
+			if int64(binary.BigEndian.Uint64(k)) <= height {
+				return false, nil
+			}
+			orphaned[string(v)] = true
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		utxos := tx.Bucket(utxoBucket)
+		err = deleteWhere(utxos, func(_, v []byte) (bool, error) {
+			var u storedUTXO
+			err := json.Unmarshal(v, &u)
+			return int64(u.Height) > height, err
+		})
+		if err != nil {
+			return err
+		}
+		var unspent []SpentUTXO
+		err = deleteWhere(tx.Bucket(spentBucket), func(_, v []byte) (bool, error) {
+			var spent SpentUTXO
+			if err := json.Unmarshal(v, &spent); err != nil || spent.SpentHeight <= height {
+				return false, err
+			}
+			if int64(spent.Height) <= height {
+				unspent = append(unspent, spent)
+			}
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, spent := range unspent {
+			v, err := json.Marshal(storedUTXO{Address: spent.Address, UTXO: spent.UTXO})
+			if err != nil {
+				return err
+			}
+			if err := utxos.Put(outpointKey(spent.TxID, spent.Vout), v); err != nil {
+				return err
+			}
+		}
+		err = deleteWhere(tx.Bucket(txBucket), func(_, v []byte) (bool, error) {
+			var t Transaction
+			err := json.Unmarshal(v, &t)
+			return orphaned[t.BlockHash], err
+		})
+		if err != nil {
+			return err
+		}
+		return putTip(tx, height, string(tx.Bucket(blockBucket).Get(heightKey(height))))
+	})
+}
+
+func (s *boltStore) PutWatched(address string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(watchBucket).Put([]byte(address), nil)
+	})
+}
+
+func (s *boltStore) RemoveWatched(address string, drop bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(watchBucket).Delete([]byte(address)); err != nil || !drop {
+			return err
+		}
+		return deleteAddresses(tx, []string{address})
+	})
+}
+
+func (s *boltStore) PutDescriptor(desc string, used []uint32) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putDescriptor(tx, desc, used)
+	})
+}
+
+func (s *boltStore) RemoveDescriptor(desc string, addresses []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(descBucket).Delete([]byte(desc)); err != nil {
+			return err
+		}
+		return deleteAddresses(tx, addresses)
+	})
+}
+
+// deleteAddresses deletes the UTXOs and spent history of addresses.
+func deleteAddresses(tx *bolt.Tx, addresses []string) error {
+	drop := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		drop[address] = true
+	}
+	err := deleteWhere(tx.Bucket(utxoBucket), func(_, v []byte) (bool, error) {
+		var u storedUTXO
+		err := json.Unmarshal(v, &u)
+		return drop[u.Address], err
+	})
+	if err != nil {
+		return err
+	}
+	return deleteWhere(tx.Bucket(spentBucket), func(_, v []byte) (bool, error) {
+		var spent SpentUTXO
+		err := json.Unmarshal(v, &spent)
+		return drop[spent.Address], err
+	})
+}
+
+// putDescriptor stores the used indexes of desc.
+func putDescriptor(tx *bolt.Tx, desc string, used []uint32) error {
+	v, err := json.Marshal(used)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(descBucket).Put([]byte(desc), v)
+}
+
+// putTip sets the tip recorded in the meta bucket.
+func putTip(tx *bolt.Tx, height int64, hash string) error {
+	meta := tx.Bucket(metaBucket)
+	if err := meta.Put(tipHeightKey, heightKey(height)); err != nil {
+		return err
+	}
+	return meta.Put(tipHashKey, []byte(hash))
+}
+
+// deleteWhere deletes the entries of b that match. Keys are collected first
+// since bbolt does not allow deleting while iterating.
+func deleteWhere(b *bolt.Bucket, match func(k, v []byte) (bool, error)) error {
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		ok, err := match(k, v)
+		if ok {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// heightKey encodes height so that keys sort by height.
+func heightKey(height int64) []byte {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], uint64(height))
+	return k[:]
+}
+
+func outpointKey(txid string, vout int) []byte {
+	return []byte(fmt.Sprintf("%s:%d", txid, vout))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bitcoin-playground/rpc"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// progressInterval is how often syncBlocks reports progress while catching up.
+const progressInterval = 10 * time.Second
+
+// hashBatchSize is the number of block hashes syncBlocks asks for per batch.
+const hashBatchSize = 500
+
+// fetchedBlock is the result of fetching the block at height.
+type fetchedBlock struct {
+	height int64
+	hash   *chainhash.Hash
+	block  *wire.MsgBlock
+	err    error
+}
+
+// syncBlocks processes the blocks from through to, in order. Up to fetchers
+// blocks are fetched ahead concurrently while the fetched ones are applied one
+// by one, so the tip always moves a block at a time and an error leaves it at
+// the last block applied; the next call resumes from there. The hashes of the
+// blocks are looked up with node in batches of hashBatchSize.
+func syncBlocks(ctx context.Context, client *rpcclient.Client, node *rpc.Client, cache *Cache, from, to int64, fetchers int) error {
+	if fetchers < 1 {
+		fetchers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each pending fetch delivers its block on its own channel; queuing those
+	// channels keeps the blocks in height order and bounds the fetches in flight.
+	pending := make(chan chan fetchedBlock, fetchers-1)
+	go func() {
+		defer close(pending)
+		for start := from; start <= to; start += hashBatchSize {
+			end := min(start+hashBatchSize-1, to)
+			hashes, err := node.GetBlockHashes(ctx, start, end)
+			if err != nil {
+				// Delivered after the blocks before it, so those still get applied.
+				result := make(chan fetchedBlock, 1)
+				result <- fetchedBlock{height: start, err: fmt.Errorf("failed to get block hashes %d-%d: %v", start, end, err)}
+				select {
+				case pending <- result:
+				case <-ctx.Done():
+				}
+				return
+			}
+			for i, hash := range hashes {
+				result := make(chan fetchedBlock, 1)
+				select {
+				case pending <- result:
+				case <-ctx.Done():
+					return
+				}
+				go func(height int64, hash string) {
+					result <- fetchBlock(client, height, hash)
+				}(start+int64(i), hash)
+			}
+		}
+	}()
+
+	total := to - from + 1
+	lastReport := time.Now()
+	for result := range pending {
+		var fb fetchedBlock
+		select {
+		case fb = <-result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if fb.err != nil {
+			return fb.err
+		}
+		// A block that does not build on the tip means the chain changed
+		// while catching up; the reorg check of the next poll handles it.
+		if _, tipHash := cache.getTip(); tipHash != "" && fb.block.Header.PrevBlock.String() != tipHash {
+			return fmt.Errorf("block %d (%s) does not extend the last processed block %s", fb.height, fb.hash, tipHash)
+		}
+		processNewBlock(cache, fb.height, fb.hash, fb.block)
+
+		done := fb.height - from + 1
+		if total == 1 {
+			fmt.Printf("Indexed UTXOs from block %d\n", fb.height)
+		} else if done == total || time.Since(lastReport) >= progressInterval {
+			fmt.Printf("Indexed UTXOs from block %d (%d/%d, %.1f%%)\n", fb.height, done, total, float64(done)*100/float64(total))
+			lastReport = time.Now()
+		}
+	}
+	return ctx.Err()
+}
+
+// fetchBlock gets the block at height, whose hash is hash, from the node.
+func fetchBlock(client *rpcclient.Client, height int64, hash string) fetchedBlock {
+	fb := fetchedBlock{height: height}
+	fb.hash, fb.err = chainhash.NewHashFromStr(hash)
+	if fb.err != nil {
+		fb.err = fmt.Errorf("invalid block hash at height %d: %v", height, fb.err)
+		return fb
+	}
+	fb.block, fb.err = client.GetBlock(fb.hash)
+	if fb.err != nil {
+		fb.err = fmt.Errorf("failed to get block %s: %v", fb.hash, fb.err)
+	}
+	return fb
+}
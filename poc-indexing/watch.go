@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"bitcoin-playground/network"
+)
+
+// watchedDescriptor is a watched output descriptor and its derivation window.
+type watchedDescriptor struct {
+	desc    *descriptor
+	used    []uint32 // Per chain, one past the highest index found paid.
+	derived []uint32 // Per chain, the number of indexes derived so far.
+}
+
+// derivedAddress locates an address derived from a watched descriptor.
+type derivedAddress struct {
+	desc  *watchedDescriptor
+	chain int
+	index uint32
+}
+
+// splitWatchList splits a comma-separated list of addresses and descriptors,
+// skipping empty entries.
+func splitWatchList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// watchEntry adds an address or output descriptor to the watch list. It
+// returns the addresses that became watched, for the caller to scan; none if
+// the entry was already watched.
+func watchEntry(cache *Cache, params *network.Params, entry string) ([]string, error) {
+	if !isDescriptor(entry) {
+		if err := params.CheckAddress(entry); err != nil {
+			return nil, err
+		}
+		if !cache.watch(entry) {
+			return nil, nil
+		}
+		return []string{entry}, nil
+	}
+	d, err := parseDescriptor(entry, activeNetParams)
+	if err != nil {
+		return nil, err
+	}
+	return cache.watchDescriptor(d), nil
+}
+
+// unwatchEntry removes an address or output descriptor from the watch list
+// and reports whether it was watched.
+func unwatchEntry(cache *Cache, entry string) bool {
+	if !isDescriptor(entry) {
+		return cache.unwatch(entry)
+	}
+	text, _, _ := strings.Cut(strings.TrimSpace(entry), "#")
+	return cache.unwatchDescriptor(text)
+}
+
+// watchDescriptor adds d to the watch list and returns the addresses of its
+// initial derivation window, or nil if d was already watched.
+func (c *Cache) watchDescriptor(d *descriptor) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.descriptors[d.text]; ok {
+		return nil
+	}
+	w := c.addDescriptor(d, nil)
+	if err := c.store.PutDescriptor(d.text, w.used); err != nil {
+		fmt.Printf("Warning: could not store watched descriptor %s: %v\n", d.text, err)
+	}
+	return c.descriptorAddresses(w)
+}
+
+// addDescriptor starts watching d, whose chains have been used up to used,
+// and derives its addresses. The caller holds c.mu or owns c.
+func (c *Cache) addDescriptor(d *descriptor, used []uint32) *watchedDescriptor {
+	w := &watchedDescriptor{
+		desc:    d,
+		used:    make([]uint32, d.numChains()),
+		derived: make([]uint32, d.numChains()),
+	}
+	copy(w.used, used)
+	c.descriptors[d.text] = w
+	c.extend(w)
+	return w
+}
+
+// extend derives the addresses of w up to the gap limit past the last used
+// one of each chain. The caller holds c.mu.
+func (c *Cache) extend(w *watchedDescriptor) {
+	for chain := range w.derived {
+		end := w.used[chain] + c.gapLimit
+		if !w.desc.ranged {
+			end = 1
+		}
+		for ; w.derived[chain] < end; w.derived[chain]++ {
+			index := w.derived[chain]
+			address, err := w.desc.address(chain, index, activeNetParams)
+			if err != nil {
+				fmt.Printf("Warning: skipping index %d of descriptor %s: %v\n", index, w.desc.text, err)
+				continue
+			}
+			if _, ok := c.derived[address]; !ok {
+				c.derived[address] = derivedAddress{desc: w, chain: chain, index: index}
+			}
+		}
+	}
+}
+
+// markUsed records that address was paid. If it was derived from a
+// descriptor, the derivation window moves past it. The caller holds c.mu.
+func (c *Cache) markUsed(changes *Changes, address string) {
+	loc, ok := c.derived[address]
+	if !ok || loc.index < loc.desc.used[loc.chain] {
+		return
+	}
+	w := loc.desc
+	w.used[loc.chain] = loc.index + 1
+	c.extend(w)
+	if changes.Descriptors == nil {
+		changes.Descriptors = make(map[string][]uint32)
+	}
+	changes.Descriptors[w.desc.text] = append([]uint32(nil), w.used...)
+}
+
+// descriptorAddresses returns the addresses derived from w so far. The
+// caller holds c.mu.
+func (c *Cache) descriptorAddresses(w *watchedDescriptor) []string {
+	var addresses []string
+	for address, loc := range c.derived {
+		if loc.desc == w {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// unwatchDescriptor removes the descriptor text from the watch list and drops
+// the UTXOs and spent history of its addresses. It reports whether it was
+// watched.
+func (c *Cache) unwatchDescriptor(text string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.descriptors[text]
+	if !ok {
+		return false
+	}
+	delete(c.descriptors, text)
+	var dropped []string
+	for _, address := range c.descriptorAddresses(w) {
+		delete(c.derived, address)
+		if !c.watched[address] {
+			c.dropAddress(address)
+			dropped = append(dropped, address)
+		}
+	}
+	if err := c.store.RemoveDescriptor(text, dropped); err != nil {
+		fmt.Printf("Warning: could not remove watched descriptor %s from the store: %v\n", text, err)
+	}
+	return true
+}
+
+// descriptorStatus describes a watched descriptor for the list command.
+type descriptorStatus struct {
+	Text          string
+	Used, Derived []uint32
+}
+
+// watchList returns the addresses watched on their own and the watched
+// descriptors, sorted.
+func (c *Cache) watchList() ([]string, []descriptorStatus) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	addresses := make([]string, 0, len(c.watched))
+	for address := range c.watched {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	descriptors := make([]descriptorStatus, 0, len(c.descriptors))
+	for text, w := range c.descriptors {
+		descriptors = append(descriptors, descriptorStatus{
+			Text:    text,
+			Used:    append([]uint32(nil), w.used...),
+			Derived: append([]uint32(nil), w.derived...),
+		})
+	}
+	sort.Slice(descriptors, func(i, j int) bool { return descriptors[i].Text < descriptors[j].Text })
+	return addresses, descriptors
+}
+
+// readWatchCommands applies the watch list commands read from r, one per
+// line, until r ends:
+//
+//	add <address|descriptor>     watch it and scan for its UTXOs
+//	remove <address|descriptor>  stop watching it and drop its UTXOs
+//	list                         print the watch list
+//
+// The addresses that become watched are sent on rescan, for the monitor to
+// scan.
+func readWatchCommands(r io.Reader, cache *Cache, params *network.Params, rescan chan<- []string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if err := runWatchCommand(cache, params, rescan, fields); err != nil {
+			fmt.Printf("Watch command failed: %v\n", err)
+		}
+	}
+}
+
+func runWatchCommand(cache *Cache, params *network.Params, rescan chan<- []string, fields []string) error {
+	switch {
+	case fields[0] == "list" && len(fields) == 1:
+		addresses, descriptors := cache.watchList()
+		fmt.Printf("Watching %d addresses and %d descriptors:\n", len(addresses), len(descriptors))
+		for _, address := range addresses {
+			fmt.Printf("  %s\n", address)
+		}
+		for _, d := range descriptors {
+			fmt.Printf("  %s (used: %v, derived: %v)\n", d.Text, d.Used, d.Derived)
+		}
+	case fields[0] == "add" && len(fields) == 2:
+		added, err := watchEntry(cache, params, fields[1])
+		if err != nil {
+			return err
+		}
+		if len(added) == 0 {
+			fmt.Printf("Already watching %s\n", fields[1])
+			return nil
+		}
+		fmt.Printf("Watching %s, scanning %d addresses for UTXOs...\n", fields[1], len(added))
+		rescan <- added
+	case fields[0] == "remove" && len(fields) == 2:
+		if !unwatchEntry(cache, fields[1]) {
+			return fmt.Errorf("%s is not watched", fields[1])
+		}
+		fmt.Printf("Stopped watching %s\n", fields[1])
+	default:
+		return fmt.Errorf("unknown command %q; use add <address|descriptor>, remove <address|descriptor> or list", strings.Join(fields, " "))
+	}
+	return nil
+}
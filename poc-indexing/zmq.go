@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"bitcoin-playground/zmq"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// zmqRetryInterval is the wait before reconnecting a failed ZMQ subscription.
+const zmqRetryInterval = 5 * time.Second
+
+// zmqBlockTopic announces new blocks. The blocks are fetched over RPC
+// anyway, so rawblock would only send each of them twice.
+const zmqBlockTopic = "hashblock"
+
+// startZMQ subscribes to the block notifications of blockEndpoint, signalling
+// newBlock for each, and to the transactions of txEndpoint, recording the
+// unconfirmed ones of the watched addresses. Empty endpoints are skipped.
+func startZMQ(ctx context.Context, blockEndpoint, txEndpoint string, cache *Cache, newBlock chan<- struct{}) {
+	topics := make(map[string][]string)
+	if blockEndpoint != "" {
+		topics[blockEndpoint] = append(topics[blockEndpoint], zmqBlockTopic)
+	}
+	if txEndpoint != "" {
+		topics[txEndpoint] = append(topics[txEndpoint], "rawtx")
+	}
+	for endpoint, t := range topics {
+		go subscribeZMQ(ctx, endpoint, t, func(n zmq.Notification) {
+			switch n.Topic {
+			case zmqBlockTopic:
+				select {
+				case newBlock <- struct{}{}:
+				default: // A check is already pending.
+				}
+			case "rawtx":
+				handleMempoolTx(cache, n.Body)
+			}
+		})
+	}
+}
+
+// subscribeZMQ passes the notifications of endpoint to handle until ctx is
+// done, reconnecting after errors. Meanwhile polling keeps the index current.
+func subscribeZMQ(ctx context.Context, endpoint string, topics []string, handle func(zmq.Notification)) {
+	for {
+		sub, err := zmq.Dial(ctx, endpoint, topics...)
+		if err == nil {
+			fmt.Printf("Subscribed to %s on %s\n", strings.Join(topics, ", "), endpoint)
+			stop := context.AfterFunc(ctx, func() { sub.Close() })
+			for {
+				var n zmq.Notification
+				if n, err = sub.Receive(); err != nil {
+					break
+				}
+				handle(n)
+			}
+			stop()
+			sub.Close()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("Warning: ZMQ subscription to %s failed, polling only: %v\n", endpoint, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(zmqRetryInterval):
+		}
+	}
+}
+
+// handleMempoolTx tracks a transaction announced by rawtx if it is relevant.
+func handleMempoolTx(cache *Cache, raw []byte) {
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		fmt.Printf("Warning: could not decode transaction from ZMQ: %v\n", err)
+		return
+	}
+	// rawtx is published again when a block confirms the transaction, so
+	// transactions already recorded are not reported twice.
+	if cache.knownTransaction(tx.TxHash().String()) {
+		return
+	}
+	trackMempoolTx(cache, &tx)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"bitcoin-playground/rpctest"
+)
+
+func TestZMQNotifications(t *testing.T) {
+	ix := newTestIndexer(t)
+	watched, other := ix.chain.NewAddress(""), ix.chain.NewAddress("")
+	ix.cache.watch(watched)
+	if err := startFullScan(ix.client, ix.node, ix.cache); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := rpctest.NewZMQPublisher(ix.chain, "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pub.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	newBlock := make(chan struct{}, 1)
+	startZMQ(ctx, pub.Endpoint, pub.Endpoint, ix.cache, newBlock)
+
+	// Subscriptions take effect asynchronously, so blocks are mined until
+	// one is announced.
+	deadline := time.Now().Add(5 * time.Second)
+	for announced := false; !announced; {
+		if time.Now().After(deadline) {
+			t.Fatal("no block was announced")
+		}
+		ix.mine()
+		select {
+		case <-newBlock:
+			announced = true
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	// Likewise, payments are made until one is picked up from rawtx.
+	// Unrelated transactions are not.
+	var paid []string
+	for len(ix.cache.mempoolTxIDs()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no transaction was picked up")
+		}
+		ix.fund(t, other, 10_000)
+		paid = append(paid, ix.fund(t, watched, 10_000))
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond) // Let the notifications in flight arrive.
+	for _, txid := range ix.cache.mempoolTxIDs() {
+		if !slices.Contains(paid, txid) {
+			t.Errorf("tracked %s, which does not pay %s", txid, watched)
+		}
+	}
+	for _, u := range ix.cache.addressUTXOs(watched) {
+		if !u.Unconfirmed {
+			t.Errorf("UTXO %s:%d of an unconfirmed tx is not marked unconfirmed", u.TxID, u.Vout)
+		}
+	}
+
+	// The block confirming it is announced, and rawtx publishes the
+	// transaction again without it being reported twice.
+	height := ix.mine()
+	select {
+	case <-newBlock:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the block confirming the payment was not announced")
+	}
+	ix.sync(t)
+	if got := ix.cache.mempoolTxIDs(); len(got) != 0 {
+		t.Errorf("tracked %v after the block was processed, want none", got)
+	}
+	want := make([]string, len(paid))
+	for i, txid := range paid {
+		want[i] = outpoint(txid, height)
+	}
+	if got := outpoints(ix.cache.addressUTXOs(watched)); !reflect.DeepEqual(got, sorted(want...)) {
+		t.Errorf("UTXOs %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"bitcoin-playground/btc"
+
+	"github.com/btcsuite/btcd/btcutil/txsort"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Payment is an output paying Amount to Address.
+type Payment struct {
+	Address string
+	Amount  btc.Amount
+}
+
+// parsePayments parses comma-separated ADDRESS=AMOUNT pairs, with amounts as
+// accepted by btc.ParseAmount, e.g. 0.0001 or 6000sat.
+func parsePayments(s string) ([]Payment, error) {
+	var payments []Payment
+	for _, p := range strings.Split(s, ",") {
+		addr, amount, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			return nil, fmt.Errorf("payment %q: expected ADDRESS=AMOUNT", p)
+		}
+		value, err := btc.ParseAmount(amount)
+		if err != nil {
+			return nil, fmt.Errorf("payment %q: %w", p, err)
+		}
+		payments = append(payments, Payment{Address: addr, Amount: value})
+	}
+	return payments, nil
+}
+
+// builderInput is an input of a TxBuilder.
+type builderInput struct {
+	coin     Coin
+	sequence uint32
+	explicit bool // sequence was set by the caller.
+}
+
+// TxBuilder assembles an unsigned transaction from any number of inputs and
+// outputs. The zero value is not usable; call NewTxBuilder.
+type TxBuilder struct {
+	Version int32
+	// LockTime is the block height, or Unix time from 500000000 on, before
+	// which the transaction cannot be mined.
+	LockTime uint32
+	// BIP69 sorts inputs and outputs deterministically (BIP69) instead of
+	// keeping the order they were added in.
+	BIP69 bool
+
+	inputs  []builderInput
+	outputs []*wire.TxOut
+}
+
+// NewTxBuilder returns an empty version 2 transaction builder; version 2
+// lets input sequences encode relative lock times (BIP68).
+func NewTxBuilder() *TxBuilder {
+	return &TxBuilder{Version: 2}
+}
+
+// AddInput spends c. Its sequence is final unless LockTime is set, in which
+// case it is one less so that the lock time is enforced.
+func (b *TxBuilder) AddInput(c Coin) {
+	b.inputs = append(b.inputs, builderInput{coin: c, sequence: wire.MaxTxInSequenceNum})
+}
+
+// AddInputWithSequence spends c with an explicit sequence.
+func (b *TxBuilder) AddInputWithSequence(c Coin, sequence uint32) {
+	b.inputs = append(b.inputs, builderInput{coin: c, sequence: sequence, explicit: true})
+}
+
+// AddOutput pays amount to address.
+func (b *TxBuilder) AddOutput(address string, amount btc.Amount) error {
+	script, err := addressScript(address)
+	if err != nil {
+		return err
+	}
+	return b.AddScriptOutput(script, amount)
+}
+
+// AddScriptOutput pays amount to a raw output script.
+func (b *TxBuilder) AddScriptOutput(script []byte, amount btc.Amount) error {
+	if amount < dustLimit {
+		return fmt.Errorf("output of %s is below the dust limit of %s", amount, dustLimit)
+	}
+	b.outputs = append(b.outputs, wire.NewTxOut(int64(amount), script))
+	return nil
+}
+
+// AddPayments adds one output per payment, batching them in one transaction.
+func (b *TxBuilder) AddPayments(payments []Payment) error {
+	for _, p := range payments {
+		if err := b.AddOutput(p.Address, p.Amount); err != nil {
+			return fmt.Errorf("payment to %s: %w", p.Address, err)
+		}
+	}
+	return nil
+}
+
+// AddOpReturn adds an unspendable output carrying up to 80 bytes of data.
+func (b *TxBuilder) AddOpReturn(data []byte) error {
+	script, err := txscript.NullDataScript(data)
+	if err != nil {
+		return err
+	}
+	b.outputs = append(b.outputs, wire.NewTxOut(0, script))
+	return nil
+}
+
+// outputTotal returns the sum of the outputs added so far.
+func (b *TxBuilder) outputTotal() btc.Amount {
+	var total btc.Amount
+	for _, out := range b.outputs {
+		total += btc.Amount(out.Value)
+	}
+	return total
+}
+
+// outputScripts returns the scripts of the outputs added so far.
+func (b *TxBuilder) outputScripts() [][]byte {
+	scripts := make([][]byte, len(b.outputs))
+	for i, out := range b.outputs {
+		scripts[i] = out.PkScript
+	}
+	return scripts
+}
+
+// Build returns the unsigned transaction and the coins its inputs spend, in
+// the order of tx.TxIn.
+func (b *TxBuilder) Build() (*wire.MsgTx, []Coin, error) {
+	if len(b.inputs) == 0 || len(b.outputs) == 0 {
+		return nil, nil, errors.New("a transaction needs at least one input and one output")
+	}
+	var in btc.Amount
+	tx := wire.NewMsgTx(b.Version)
+	tx.LockTime = b.LockTime
+	coins := make(map[wire.OutPoint]Coin, len(b.inputs))
+	for _, input := range b.inputs {
+		txHash, err := chainhash.NewHashFromStr(input.coin.TxID)
+		if err != nil {
+			return nil, nil, err
+		}
+		outPoint := wire.NewOutPoint(txHash, input.coin.Vout)
+		if _, dup := coins[*outPoint]; dup {
+			return nil, nil, fmt.Errorf("input %s is spent twice", outPoint)
+		}
+		coins[*outPoint] = input.coin
+		in += input.coin.Amount
+
+		txIn := wire.NewTxIn(outPoint, nil, nil)
+		txIn.Sequence = input.sequence
+		if b.LockTime != 0 && !input.explicit {
+			txIn.Sequence = wire.MaxTxInSequenceNum - 1
+		}
+		tx.AddTxIn(txIn)
+	}
+	for _, out := range b.outputs {
+		tx.AddTxOut(wire.NewTxOut(out.Value, out.PkScript))
+	}
+	if out := b.outputTotal(); out > in {
+		return nil, nil, fmt.Errorf("outputs of %s exceed inputs of %s", out, in)
+	}
+
+	if b.BIP69 {
+		txsort.InPlaceSort(tx)
+	}
+	inputs := make([]Coin, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		inputs[i] = coins[txIn.PreviousOutPoint]
+	}
+	return tx, inputs, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bitcoin-playground/btc"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// testCoin returns a coin of amount paying recipient, at output vout of a
+// transaction whose txid repeats the hex digit digit.
+func testCoin(t *testing.T, digit string, vout uint32, amount btc.Amount) Coin {
+	t.Helper()
+	script, err := addressScript(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Coin{
+		TxID:         strings.Repeat(digit, 64),
+		Vout:         vout,
+		Address:      recipient,
+		ScriptPubKey: fmt.Sprintf("%x", script),
+		Amount:       amount,
+	}
+}
+
+// outpoints returns "txid:vout" of the inputs of tx, or of coins.
+func outpoints(tx *wire.MsgTx, coins []Coin) []string {
+	var ops []string
+	if tx != nil {
+		for _, in := range tx.TxIn {
+			ops = append(ops, in.PreviousOutPoint.String())
+		}
+		return ops
+	}
+	for _, c := range coins {
+		ops = append(ops, fmt.Sprintf("%s:%d", c.TxID, c.Vout))
+	}
+	return ops
+}
+
+func TestParsePayments(t *testing.T) {
+	got, err := parsePayments(recipient + "=0.0001, " + changeAddress + "=6000sat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Payment{{recipient, 10_000}, {changeAddress, 6000}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePayments = %+v, want %+v", got, want)
+	}
+
+	for _, s := range []string{
+		recipient,                  // No amount.
+		recipient + "=",            // Empty amount.
+		recipient + "=ten",         // Not a number.
+		recipient + "=0.000000001", // Below a satoshi.
+		recipient + "=1,",          // Empty payment.
+	} {
+		if _, err := parsePayments(s); err == nil {
+			t.Errorf("parsePayments(%q) succeeded", s)
+		}
+	}
+}
+
+func TestBuildKeepsOrder(t *testing.T) {
+	b := NewTxBuilder()
+	b.AddInput(testCoin(t, "b", 0, 30_000))
+	b.AddInput(testCoin(t, "a", 1, 20_000))
+	if err := b.AddPayments([]Payment{{recipient, 20_000}, {changeAddress, 10_000}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddOpReturn([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	tx, inputs, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.Version != 2 || tx.LockTime != 0 {
+		t.Errorf("version %d, locktime %d, want 2 and 0", tx.Version, tx.LockTime)
+	}
+	want := []string{strings.Repeat("b", 64) + ":0", strings.Repeat("a", 64) + ":1"}
+	if got := outpoints(tx, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("inputs %v, want %v", got, want)
+	}
+	if got := outpoints(nil, inputs); !reflect.DeepEqual(got, want) {
+		t.Errorf("coins %v, want %v", got, want)
+	}
+	for i, in := range tx.TxIn {
+		if in.Sequence != wire.MaxTxInSequenceNum {
+			t.Errorf("input %d sequence %#x, want final", i, in.Sequence)
+		}
+	}
+	if len(tx.TxOut) != 3 || tx.TxOut[0].Value != 20_000 || tx.TxOut[1].Value != 10_000 {
+		t.Fatalf("outputs %+v, want the payments then OP_RETURN", tx.TxOut)
+	}
+	data, err := txscript.PushedData(tx.TxOut[2].PkScript)
+	if err != nil || txscript.GetScriptClass(tx.TxOut[2].PkScript) != txscript.NullDataTy ||
+		tx.TxOut[2].Value != 0 || !bytes.Equal(bytes.Join(data, nil), []byte("hello")) {
+		t.Errorf("output 2 %x of %d, want OP_RETURN \"hello\" of 0", tx.TxOut[2].PkScript, tx.TxOut[2].Value)
+	}
+}
+
+func TestBuildBIP69(t *testing.T) {
+	b := NewTxBuilder()
+	b.BIP69 = true
+	b.AddInput(testCoin(t, "b", 0, 30_000))
+	b.AddInput(testCoin(t, "a", 2, 10_000))
+	b.AddInput(testCoin(t, "a", 1, 20_000))
+	if err := b.AddPayments([]Payment{{recipient, 30_000}, {changeAddress, 10_000}, {recipient, 10_000}}); err != nil {
+		t.Fatal(err)
+	}
+	tx, inputs, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Input txids are compared in the byte order they are displayed in.
+	want := []string{strings.Repeat("a", 64) + ":1", strings.Repeat("a", 64) + ":2", strings.Repeat("b", 64) + ":0"}
+	if got := outpoints(tx, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("inputs %v, want %v", got, want)
+	}
+	// The coins follow the inputs, so that each input is signed for the
+	// amount it spends.
+	if got := outpoints(nil, inputs); !reflect.DeepEqual(got, want) {
+		t.Errorf("coins %v, want %v", got, want)
+	}
+	if inputs[0].Amount != 20_000 || inputs[1].Amount != 10_000 {
+		t.Errorf("coin amounts %s and %s, want 20000 and 10000 sat", inputs[0].Amount, inputs[1].Amount)
+	}
+	for i := 1; i < len(tx.TxOut); i++ {
+		prev, out := tx.TxOut[i-1], tx.TxOut[i]
+		if prev.Value > out.Value || prev.Value == out.Value && bytes.Compare(prev.PkScript, out.PkScript) > 0 {
+			t.Errorf("outputs %d and %d are not in BIP69 order", i-1, i)
+		}
+	}
+}
+
+func TestBuildLockTime(t *testing.T) {
+	b := NewTxBuilder()
+	b.LockTime = 500_000
+	b.AddInput(testCoin(t, "a", 0, 20_000))
+	b.AddInputWithSequence(testCoin(t, "b", 0, 20_000), 10)
+	if err := b.AddOutput(recipient, 30_000); err != nil {
+		t.Fatal(err)
+	}
+	tx, _, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.LockTime != 500_000 {
+		t.Errorf("locktime %d, want 500000", tx.LockTime)
+	}
+	// Final sequences would disable the lock time; explicit ones are kept.
+	if tx.TxIn[0].Sequence != wire.MaxTxInSequenceNum-1 || tx.TxIn[1].Sequence != 10 {
+		t.Errorf("sequences %#x and %#x, want 0xfffffffe and 0xa", tx.TxIn[0].Sequence, tx.TxIn[1].Sequence)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	coin := testCoin(t, "a", 0, 20_000)
+	tests := []struct {
+		name  string
+		build func(b *TxBuilder) error
+		want  string
+	}{
+		{"no inputs", func(b *TxBuilder) error {
+			return b.AddOutput(recipient, 10_000)
+		}, "at least one input"},
+		{"no outputs", func(b *TxBuilder) error {
+			b.AddInput(coin)
+			return nil
+		}, "at least one input"},
+		{"outputs exceed inputs", func(b *TxBuilder) error {
+			b.AddInput(coin)
+			return b.AddOutput(recipient, 20_001)
+		}, "exceed inputs"},
+		{"coin spent twice", func(b *TxBuilder) error {
+			b.AddInput(coin)
+			b.AddInput(coin)
+			return b.AddOutput(recipient, 10_000)
+		}, "spent twice"},
+		{"invalid txid", func(b *TxBuilder) error {
+			b.AddInput(Coin{TxID: "xyz", Amount: 20_000})
+			return b.AddOutput(recipient, 10_000)
+		}, "encoding/hex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewTxBuilder()
+			if err := tt.build(b); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := b.Build(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Build error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddOutputErrors(t *testing.T) {
+	b := NewTxBuilder()
+	if err := b.AddOutput(recipient, dustLimit-1); err == nil || !strings.Contains(err.Error(), "dust") {
+		t.Errorf("output below the dust limit: error %v", err)
+	}
+	if err := b.AddOutput(recipient, dustLimit); err != nil {
+		t.Errorf("output at the dust limit: %v", err)
+	}
+	// A mainnet address on testnet4.
+	if err := b.AddOutput("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", 10_000); err == nil {
+		t.Error("added an output paying a mainnet address")
+	}
+	if err := b.AddPayments([]Payment{{recipient, 10_000}, {changeAddress, 1}}); err == nil || !strings.Contains(err.Error(), changeAddress) {
+		t.Errorf("payment below the dust limit: error %v, want one naming %s", err, changeAddress)
+	}
+	if err := b.AddOpReturn(make([]byte, txscript.MaxDataCarrierSize+1)); err == nil {
+		t.Errorf("added an OP_RETURN output of %d bytes", txscript.MaxDataCarrierSize+1)
+	}
+	if got := b.outputTotal(); got != dustLimit+10_000 {
+		t.Errorf("outputTotal = %s, want %s", got, dustLimit+10_000)
+	}
+}
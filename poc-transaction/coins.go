@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/rpc"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// keyring holds the private keys that may sign inputs, by the hex
+// scriptPubKey they unlock.
+type keyring map[string]signingKey
+
+// signingKey signs the inputs spending one scriptPubKey.
+type signingKey struct {
+	*btcutil.WIF
+	tapLeaf *tapLeaf // Script path of a P2TR output; nil for other spends.
+}
+
+// newKeyring decodes WIF private keys. Each unlocks the P2PKH output of its
+// public key and, if the key is compressed, its P2WPKH, P2SH-P2WPKH and P2TR
+// outputs; the latter by key path, with the key tweaked as in BIP86. Each
+// tapscript, "LEAF:CONTROLBLOCK" in hex, unlocks the P2TR output it is
+// committed to by script path, signed with the key the leaf names.
+func newKeyring(wifs, tapscripts []string) (keyring, error) {
+	keys := make(keyring)
+	var decoded []*btcutil.WIF
+	for _, s := range wifs {
+		wif, err := btcutil.DecodeWIF(s)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding WIF: %w", err)
+		}
+		if !wif.IsForNet(activeNetParams) {
+			return nil, fmt.Errorf("private key is not for %s", activeNetParams.Name)
+		}
+		addr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(wif.SerializePubKey()), activeNetParams)
+		if err != nil {
+			return nil, err
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		keys[hex.EncodeToString(script)] = signingKey{WIF: wif}
+		decoded = append(decoded, wif)
+		if !wif.CompressPubKey {
+			continue // Segwit requires compressed keys.
+		}
+
+		witnessScript, err := p2wpkhScript(wif)
+		if err != nil {
+			return nil, err
+		}
+		keys[hex.EncodeToString(witnessScript)] = signingKey{WIF: wif}
+		nested, err := btcutil.NewAddressScriptHash(witnessScript, activeNetParams)
+		if err != nil {
+			return nil, err
+		}
+		if script, err = txscript.PayToAddrScript(nested); err != nil {
+			return nil, err
+		}
+		keys[hex.EncodeToString(script)] = signingKey{WIF: wif}
+
+		outputKey := txscript.ComputeTaprootKeyNoScript(wif.PrivKey.PubKey())
+		if script, err = txscript.PayToTaprootScript(outputKey); err != nil {
+			return nil, err
+		}
+		keys[hex.EncodeToString(script)] = signingKey{WIF: wif}
+	}
+
+	for _, s := range tapscripts {
+		leaf, script, err := parseTapLeaf(s)
+		if err != nil {
+			return nil, err
+		}
+		wif, err := leaf.signer(decoded)
+		if err != nil {
+			return nil, err
+		}
+		keys[hex.EncodeToString(script)] = signingKey{WIF: wif, tapLeaf: leaf}
+	}
+	return keys, nil
+}
+
+// addresses returns the addresses of the keys.
+func (k keyring) addresses() []string {
+	var addresses []string
+	for script := range k {
+		if addr, err := scriptAddress(script); err == nil {
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses
+}
+
+// spendable keeps the coins the keyring can sign for, with the script path
+// of those spent through one and whether their key is uncompressed.
+func (k keyring) spendable(coins []Coin) []Coin {
+	var kept []Coin
+	for _, c := range coins {
+		if key, ok := k[c.ScriptPubKey]; ok {
+			c.tapLeaf = key.tapLeaf
+			c.uncompressed = !key.CompressPubKey
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// scriptAddress returns the address a hex scriptPubKey pays.
+func scriptAddress(script string) (string, error) {
+	b, err := hex.DecodeString(script)
+	if err != nil {
+		return "", err
+	}
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(b, activeNetParams)
+	if err != nil || len(addrs) != 1 {
+		return "", fmt.Errorf("no address in script %s", script)
+	}
+	return addrs[0].EncodeAddress(), nil
+}
+
+// walletCoins returns the confirmed, spendable outputs listunspent reports
+// for addresses.
+func walletCoins(ctx context.Context, wallet *rpc.Client, addresses []string) ([]Coin, error) {
+	var coins []Coin
+	for _, address := range addresses {
+		unspent, err := wallet.ListUnspent(ctx, address)
+		if err != nil {
+			return nil, fmt.Errorf("listunspent %s: %w", address, err)
+		}
+		for _, u := range unspent {
+			if !u.Spendable || u.Confirmations < 1 {
+				continue
+			}
+			coins = append(coins, Coin{
+				TxID:         u.TxID,
+				Vout:         u.Vout,
+				Address:      u.Address,
+				ScriptPubKey: u.ScriptPubKey,
+				Amount:       u.Amount,
+			})
+		}
+	}
+	return coins, nil
+}
+
+// indexedUTXO is a UTXO as served by the poc-indexing HTTP API.
+type indexedUTXO struct {
+	TxID         string     `json:"txid"`
+	Vout         uint32     `json:"vout"`
+	Amount       btc.Amount `json:"amount"`
+	Unconfirmed  bool       `json:"unconfirmed"`
+	PendingSpend string     `json:"pendingSpend"`
+}
+
+// indexerCoins returns the confirmed UTXOs of addresses known to the
+// poc-indexing HTTP API at baseURL that no mempool transaction spends yet.
+// Addresses the indexer does not watch are taken to hold no coins.
+func indexerCoins(ctx context.Context, baseURL string, addresses []string) ([]Coin, error) {
+	var coins []Coin
+	for _, address := range addresses {
+		addr, err := btcutil.DecodeAddress(address, activeNetParams)
+		if err != nil {
+			return nil, err
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		utxos, err := fetchIndexedUTXOs(ctx, baseURL, address)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range utxos {
+			if u.Unconfirmed || u.PendingSpend != "" {
+				continue
+			}
+			coins = append(coins, Coin{
+				TxID:         u.TxID,
+				Vout:         u.Vout,
+				Address:      address,
+				ScriptPubKey: hex.EncodeToString(script),
+				Amount:       u.Amount,
+			})
+		}
+	}
+	return coins, nil
+}
+
+// fetchIndexedUTXOs returns the UTXOs the indexer holds for address, none if
+// it does not watch the address.
+func fetchIndexedUTXOs(ctx context.Context, baseURL, address string) ([]indexedUTXO, error) {
+	u := strings.TrimSuffix(baseURL, "/") + "/utxos?address=" + url.QueryEscape(address)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return nil, fmt.Errorf("indexer: %s: %s", resp.Status, e.Error)
+	}
+	var utxos []indexedUTXO
+	if err := json.NewDecoder(resp.Body).Decode(&utxos); err != nil {
+		return nil, fmt.Errorf("indexer: %w", err)
+	}
+	return utxos, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/network"
+	"bitcoin-playground/rpctest"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// startIndexer builds poc-indexing and runs it against the node at nodeURL,
+// watching watch, until the test ends. It returns the URL of its HTTP API.
+func startIndexer(t *testing.T, nodeURL string, watch ...string) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is needed to build poc-indexing")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "indexer")
+	build := exec.Command(goTool, "build", "-o", bin, ".")
+	build.Dir = filepath.Join("..", "poc-indexing")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building poc-indexing: %v\n%s", err, out)
+	}
+
+	cmd := exec.Command(bin, "-network", "regtest", "-node-url", nodeURL, "-node-user", "admin", "-node-pass", "admin",
+		"-db", "", "-mempool=false", "-http", "127.0.0.1:0", "-watch", strings.Join(watch, ","))
+	cmd.Env = append(os.Environ(), "HOME="+dir, "BTC101_CONFIG=")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	listening := make(chan string, 1)
+	go func() {
+		// Reading on keeps the indexer from blocking on its output.
+		for s := bufio.NewScanner(stdout); s.Scan(); {
+			if url, ok := strings.CutPrefix(s.Text(), "HTTP API listening on "); ok {
+				listening <- url
+			}
+		}
+		close(listening)
+	}()
+	select {
+	case url, ok := <-listening:
+		if !ok {
+			t.Fatal("poc-indexing exited before serving its HTTP API")
+		}
+		return url
+	case <-time.After(30 * time.Second):
+		t.Fatal("poc-indexing did not serve its HTTP API")
+	}
+	return ""
+}
+
+func TestIndexerCoins(t *testing.T) {
+	params := activeNetParams
+	activeNetParams = &chaincfg.RegressionNetParams
+	t.Cleanup(func() { activeNetParams = params })
+
+	key := testKey(t, "indexed", true)
+	keys, err := newKeyring([]string{key.String()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts := keyScripts(keys, key)
+	watched, err := scriptAddress(scripts["pubkeyhash"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwatched, err := scriptAddress(scripts["witness_v0_keyhash"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := rpctest.NewChain(&network.Regtest)
+	srv := rpctest.NewServer(chain, "admin", "admin")
+	t.Cleanup(srv.Close)
+	fund := func(addr string, amount btc.Amount) string {
+		t.Helper()
+		txid, err := chain.Fund(addr, amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return txid
+	}
+	confirmed := fund(watched, 20_000)
+	fund(unwatched, 30_000)
+	chain.Mine(1)
+
+	// Only one of the keyring's addresses is watched; the indexer answers
+	// 404 for the others.
+	api := startIndexer(t, srv.URL, watched)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	addresses := keys.addresses()
+	slices.Sort(addresses)
+	coins, err := indexerCoins(ctx, api, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Coin{{
+		TxID:         confirmed,
+		Vout:         0,
+		Address:      watched,
+		ScriptPubKey: scripts["pubkeyhash"],
+		Amount:       20_000,
+	}}
+	if !reflect.DeepEqual(coins, want) {
+		t.Errorf("indexerCoins = %+v, want %+v", coins, want)
+	}
+}
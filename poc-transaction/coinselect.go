@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sort"
+
+	"bitcoin-playground/btc"
+)
+
+// Coin is a spendable output offered to coin selection.
+type Coin struct {
+	TxID         string
+	Vout         uint32
+	Address      string
+	ScriptPubKey string // Hex.
+	Amount       btc.Amount
+
+	tapLeaf      *tapLeaf // Script path of a P2TR coin, if it is not spent by key path.
+	uncompressed bool     // P2PKH coin of an uncompressed public key.
+}
+
+// Strategy names a coin selection algorithm.
+type Strategy string
+
+const (
+	// BranchAndBound searches for a set of coins that pays the target and
+	// fee without a change output, wasting at most the cost of one.
+	BranchAndBound Strategy = "bnb"
+	// LargestFirst spends the largest coins first; it uses few inputs.
+	LargestFirst Strategy = "largest-first"
+	// Knapsack picks the smallest coin covering the target, or a random
+	// approximation of the subset of smaller coins closest to it.
+	Knapsack Strategy = "knapsack"
+	// Auto tries BranchAndBound and falls back to Knapsack, like Bitcoin Core.
+	Auto Strategy = "auto"
+)
+
+// Strategies lists the accepted strategies.
+var Strategies = []Strategy{Auto, BranchAndBound, LargestFirst, Knapsack}
+
+// bnbMaxTries bounds the branch-and-bound search, as in Bitcoin Core.
+const bnbMaxTries = 100000
+
+// knapsackRounds is the number of random subsets Knapsack tries.
+const knapsackRounds = 1000
+
+// errInsufficientFunds is returned when the coins cannot pay the target and fee.
+var errInsufficientFunds = errors.New("insufficient funds")
+
+// SelectionParams describe the transaction the coins are selected for.
+type SelectionParams struct {
+	Target   btc.Amount // Sum of the payment outputs.
+	Outputs  [][]byte   // Scripts of the payment outputs.
+	Change   []byte     // Script of the change output, if there is one.
+	FeeRate  FeeRate
+	Strategy Strategy
+	// SingleAddress spends the coins of one address only, so that the
+	// transaction does not link several addresses of the wallet together.
+	SingleAddress bool
+}
+
+// Selection is the outcome of coin selection.
+type Selection struct {
+	Inputs []Coin
+	Fee    btc.Amount
+	// Change is the value of the change output, or 0 if the excess was
+	// too small to be worth one and went to the fee.
+	Change btc.Amount
+	VSize  int // Estimated virtual size of the signed transaction.
+}
+
+// selectCoins picks the inputs that pay p.Target plus the fee at p.FeeRate.
+func selectCoins(coins []Coin, p SelectionParams) (Selection, error) {
+	if p.Strategy != "" && !slices.Contains(Strategies, p.Strategy) {
+		return Selection{}, fmt.Errorf("unknown coin selection strategy %q", p.Strategy)
+	}
+	if !p.SingleAddress {
+		return selectFrom(coins, p)
+	}
+	groups := make(map[string][]Coin)
+	for _, c := range coins {
+		groups[c.Address] = append(groups[c.Address], c)
+	}
+	var best Selection
+	found := false
+	for _, group := range groups {
+		sel, err := selectFrom(group, p)
+		if err != nil {
+			continue
+		}
+		if !found || sel.Fee < best.Fee || (sel.Fee == best.Fee && len(sel.Inputs) < len(best.Inputs)) {
+			best, found = sel, true
+		}
+	}
+	if !found {
+		return Selection{}, fmt.Errorf("%w: no single address can pay %s plus fee", errInsufficientFunds, p.Target)
+	}
+	return best, nil
+}
+
+// candidate is a coin with its effective value: its amount minus the fee
+// to spend it.
+type candidate struct {
+	coin      Coin
+	effective btc.Amount
+}
+
+// selectFrom runs p.Strategy on coins.
+func selectFrom(coins []Coin, p SelectionParams) (Selection, error) {
+	// Coins worth less than the fee to spend them are left out, and so are
+	// those whose script is not supported.
+	var usable []candidate
+	var total btc.Amount
+	for _, c := range coins {
+		vsize, err := coinVSize(c)
+		if err != nil {
+			continue
+		}
+		if v := c.Amount - p.FeeRate.fee(vsize); v > 0 {
+			usable = append(usable, candidate{c, v})
+			total += v
+		}
+	}
+	// target is what the inputs must contribute net of their own fee.
+	est := newSizeEstimate()
+	for _, script := range p.Outputs {
+		est.addOutput(script)
+	}
+	target := p.Target + p.FeeRate.fee(est.vsize())
+	if total < target {
+		return Selection{}, fmt.Errorf("%w: need %s plus fee, have %s spendable", errInsufficientFunds, p.Target, total)
+	}
+	// A change output costs its own fee now and an input's fee when spent.
+	costOfChange := p.FeeRate.fee(vbytes(outputWeight(p.Change)))
+	if w, _, err := inputWeight(p.Change, nil, false); err == nil {
+		costOfChange += p.FeeRate.fee(vbytes(w))
+	}
+
+	var inputs []Coin
+	switch p.Strategy {
+	case BranchAndBound:
+		inputs = branchAndBound(usable, target, costOfChange)
+		if inputs == nil {
+			return Selection{}, fmt.Errorf("branch and bound found no selection without change")
+		}
+	case LargestFirst:
+		inputs = largestFirst(usable, target)
+	case Knapsack:
+		inputs = knapsack(usable, target+costOfChange)
+	default: // Auto
+		if inputs = branchAndBound(usable, target, costOfChange); inputs == nil {
+			inputs = knapsack(usable, target+costOfChange)
+		}
+	}
+	if inputs == nil {
+		return Selection{}, fmt.Errorf("%w: need %s plus fee", errInsufficientFunds, p.Target)
+	}
+	return finishSelection(inputs, p)
+}
+
+// finishSelection computes the size, fee and change of spending inputs.
+// Change below the dust limit is left to the fee.
+func finishSelection(inputs []Coin, p SelectionParams) (Selection, error) {
+	est := newSizeEstimate()
+	var total btc.Amount
+	for _, c := range inputs {
+		script, err := hex.DecodeString(c.ScriptPubKey)
+		if err != nil {
+			return Selection{}, err
+		}
+		if err := est.addInput(script, c.tapLeaf, c.uncompressed); err != nil {
+			return Selection{}, err
+		}
+		total += c.Amount
+	}
+	for _, script := range p.Outputs {
+		est.addOutput(script)
+	}
+	noChange := Selection{Inputs: inputs, Fee: total - p.Target, VSize: est.vsize()}
+
+	est.addOutput(p.Change)
+	fee := p.FeeRate.fee(est.vsize())
+	if change := total - p.Target - fee; change >= dustLimit {
+		return Selection{Inputs: inputs, Fee: fee, Change: change, VSize: est.vsize()}, nil
+	}
+	return noChange, nil
+}
+
+// sortedByValue returns candidates from the largest effective value to the
+// smallest.
+func sortedByValue(coins []candidate) []candidate {
+	sorted := append([]candidate(nil), coins...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].effective > sorted[j].effective })
+	return sorted
+}
+
+// branchAndBound searches depth first for the subset whose effective value
+// lies within [target, target+costOfChange] with the least excess. It returns
+// nil if there is none.
+func branchAndBound(coins []candidate, target, costOfChange btc.Amount) []Coin {
+	sorted := sortedByValue(coins)
+	var remaining btc.Amount
+	for _, c := range sorted {
+		remaining += c.effective
+	}
+
+	var best []bool
+	bestExcess := costOfChange + 1
+	selected := make([]bool, len(sorted))
+	var sum btc.Amount
+	depth := 0
+	for tries := 0; tries < bnbMaxTries; tries++ {
+		backtrack := false
+		switch {
+		case sum+remaining < target || sum > target+costOfChange:
+			backtrack = true
+		case sum >= target:
+			if excess := sum - target; excess < bestExcess {
+				best, bestExcess = append([]bool(nil), selected...), excess
+			}
+			backtrack = true
+		}
+
+		if backtrack {
+			// Undo the last inclusion and explore its omission instead.
+			for depth > 0 && !selected[depth-1] {
+				depth--
+				remaining += sorted[depth].effective
+			}
+			if depth == 0 {
+				break
+			}
+			depth--
+			selected[depth] = false
+			sum -= sorted[depth].effective
+			depth++
+			continue
+		}
+		// Include the next coin.
+		remaining -= sorted[depth].effective
+		selected[depth] = true
+		sum += sorted[depth].effective
+		depth++
+	}
+	if best == nil {
+		return nil
+	}
+	return selectedCoins(sorted, best)
+}
+
+// largestFirst adds the coins with the largest effective value until they
+// reach target.
+func largestFirst(coins []candidate, target btc.Amount) []Coin {
+	var inputs []Coin
+	var sum btc.Amount
+	for _, c := range sortedByValue(coins) {
+		inputs = append(inputs, c.coin)
+		if sum += c.effective; sum >= target {
+			return inputs
+		}
+	}
+	return nil
+}
+
+// knapsack returns the smallest coin whose effective value covers target, or
+// the subset of the smaller coins closest to target found in random rounds,
+// whichever wastes less.
+func knapsack(coins []candidate, target btc.Amount) []Coin {
+	var smaller []candidate
+	var smallerTotal btc.Amount
+	var lowestLarger *candidate
+	for i, c := range coins {
+		switch {
+		case c.effective == target:
+			return []Coin{c.coin}
+		case c.effective < target:
+			smaller = append(smaller, c)
+			smallerTotal += c.effective
+		case lowestLarger == nil || c.effective < lowestLarger.effective:
+			lowestLarger = &coins[i]
+		}
+	}
+	if smallerTotal <= target {
+		if smallerTotal == target || lowestLarger == nil {
+			return selectedCoins(smaller, nil) // All of them.
+		}
+		return []Coin{lowestLarger.coin}
+	}
+
+	smaller = sortedByValue(smaller)
+	best := make([]bool, len(smaller))
+	for i := range best {
+		best[i] = true
+	}
+	bestSum := smallerTotal
+	selected := make([]bool, len(smaller))
+	for round := 0; round < knapsackRounds && bestSum != target; round++ {
+		clear(selected)
+		var sum btc.Amount
+		reached := false
+		// Two passes: random picks first, then the coins left out.
+		for pass := 0; pass < 2 && !reached; pass++ {
+			for i, c := range smaller {
+				if selected[i] || (pass == 0 && rand.IntN(2) == 0) {
+					continue
+				}
+				sum += c.effective
+				selected[i] = true
+				if sum >= target {
+					reached = true
+					if sum < bestSum {
+						bestSum = sum
+						copy(best, selected)
+					}
+					sum -= c.effective
+					selected[i] = false
+				}
+			}
+		}
+	}
+
+	if lowestLarger != nil && lowestLarger.effective <= bestSum {
+		return []Coin{lowestLarger.coin}
+	}
+	return selectedCoins(smaller, best)
+}
+
+// selectedCoins returns the coins of candidates whose flag is set, or all
+// of them if flags is nil.
+func selectedCoins(candidates []candidate, flags []bool) []Coin {
+	var coins []Coin
+	for i, c := range candidates {
+		if flags == nil || flags[i] {
+			coins = append(coins, c.coin)
+		}
+	}
+	return coins
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"bitcoin-playground/btc"
+)
+
+func TestSelectCoins(t *testing.T) {
+	// At 1 sat/vB a P2PKH input costs 148 sat and the transaction paying
+	// recipient 44 sat, so a coin of target+192 pays it exactly. A P2PKH
+	// change output adds 34 sat.
+	const target = 10_000
+	exact := testCoin(t, "1", 0, target+192)
+	small, medium, large := testCoin(t, "2", 0, 6_000), testCoin(t, "3", 0, 9_000), testCoin(t, "4", 0, 30_000)
+	fromA, alsoFromA, fromB := small, testCoin(t, "5", 1, 6_000), medium
+	fromA.Address, alsoFromA.Address, fromB.Address = "a", "a", "b"
+
+	tests := []struct {
+		name     string
+		coins    []Coin
+		target   btc.Amount
+		strategy Strategy
+		single   bool
+		want     []Coin
+		change   btc.Amount
+		err      string
+	}{
+		{name: "branch and bound exact match", coins: []Coin{large, exact, small}, target: target, strategy: BranchAndBound, want: []Coin{exact}},
+		{name: "auto exact match", coins: []Coin{large, exact, small}, target: target, strategy: Auto, want: []Coin{exact}},
+		{name: "branch and bound without a match", coins: []Coin{large}, target: target, strategy: BranchAndBound, err: "no selection without change"},
+		// No subset lands close enough to the target to skip change, so the
+		// smallest coin covering it is spent with change.
+		{name: "auto falls back to knapsack", coins: []Coin{large, testCoin(t, "6", 0, 50_000)}, target: target, strategy: Auto,
+			want: []Coin{large}, change: 30_000 - target - 226},
+		{name: "knapsack", coins: []Coin{large, small, medium}, target: target, strategy: Knapsack,
+			want: []Coin{small, medium}, change: 15_000 - target - 374},
+		{name: "largest first", coins: []Coin{small, large, medium}, target: 35_000, strategy: LargestFirst,
+			want: []Coin{large, medium}, change: 39_000 - 35_000 - 374},
+		// 300 sat over the exact amount leave 266 sat of change, which is dust.
+		{name: "dust change goes to the fee", coins: []Coin{testCoin(t, "7", 0, target+492)}, target: target, strategy: LargestFirst,
+			want: []Coin{testCoin(t, "7", 0, target+492)}},
+		{name: "insufficient funds", coins: []Coin{small, medium}, target: 15_000, strategy: Auto, err: errInsufficientFunds.Error()},
+		{name: "coins worth less than their fee", coins: []Coin{testCoin(t, "8", 0, 148)}, target: 1, strategy: LargestFirst, err: errInsufficientFunds.Error()},
+		{name: "unknown strategy", coins: []Coin{large}, target: target, strategy: "random", err: "unknown coin selection strategy"},
+		// Largest first takes the coins of both addresses, unless a single
+		// one must pay.
+		{name: "mixed addresses", coins: []Coin{fromA, alsoFromA, fromB}, target: target, strategy: LargestFirst,
+			want: []Coin{fromB, fromA}, change: 15_000 - target - 374},
+		{name: "single address", coins: []Coin{fromA, alsoFromA, fromB}, target: target, strategy: LargestFirst, single: true,
+			want: []Coin{fromA, alsoFromA}, change: 12_000 - target - 374},
+		{name: "no single address suffices", coins: []Coin{fromA, fromB}, target: target, strategy: LargestFirst, single: true,
+			err: "no single address can pay"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := selectCoins(tt.coins, SelectionParams{
+				Target:        tt.target,
+				Outputs:       [][]byte{mustAddressScript(t, recipient)},
+				Change:        mustAddressScript(t, changeAddress),
+				FeeRate:       1,
+				Strategy:      tt.strategy,
+				SingleAddress: tt.single,
+			})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("selectCoins error %v, want one containing %q", err, tt.err)
+				}
+				if tt.err == errInsufficientFunds.Error() && !errors.Is(err, errInsufficientFunds) {
+					t.Errorf("selectCoins error %v does not wrap errInsufficientFunds", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, want := outpoints(nil, sel.Inputs), outpoints(nil, tt.want)
+			slices.Sort(got)
+			slices.Sort(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("inputs %v, want %v", got, want)
+			}
+			if sel.Change != tt.change {
+				t.Errorf("change %s, want %s", sel.Change, tt.change)
+			}
+			// Whatever is not paid or returned as change goes to the fee,
+			// which covers the estimated size.
+			var total btc.Amount
+			for _, c := range sel.Inputs {
+				total += c.Amount
+			}
+			if sel.Fee != total-tt.target-sel.Change || sel.Fee < FeeRate(1).fee(sel.VSize) {
+				t.Errorf("fee %s for %d vB, spending %s on %s and %s of change", sel.Fee, sel.VSize, total, tt.target, sel.Change)
+			}
+		})
+	}
+}
+
+// mustAddressScript returns the scriptPubKey paying address.
+func mustAddressScript(t *testing.T, address string) []byte {
+	t.Helper()
+	script, err := addressScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
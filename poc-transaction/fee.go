@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"bitcoin-playground/btc"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// FeeRate is a fee rate in satoshis per virtual byte.
+type FeeRate float64
+
+// fee returns the fee of a transaction of vsize vbytes, rounded up.
+func (r FeeRate) fee(vsize int) btc.Amount {
+	return btc.Amount(math.Ceil(float64(r) * float64(vsize)))
+}
+
+func (r FeeRate) String() string {
+	return strconv.FormatFloat(float64(r), 'f', -1, 64) + " sat/vB"
+}
+
+// Transaction weights, in weight units; a vbyte is 4 of them. Signatures are
+// counted at their largest DER encoding, so estimates may exceed the signed
+// size by a few vbytes but never fall short.
+const (
+	witnessScaleFactor = 4
+	// Version and locktime; the input and output counts are added as their
+	// varints grow.
+	txOverheadWeight = witnessScaleFactor * (4 + 4)
+	// Marker and flag of a transaction with witnesses.
+	segwitMarkerWeight = 2
+	// Outpoint, script length and sequence of an input.
+	txInBaseWeight = witnessScaleFactor * (32 + 4 + 1 + 4)
+
+	compressedPubKeySize    = 33
+	uncompressedPubKeySize  = 65
+	p2pkhSigScriptSize      = 1 + 72 + 1 // <sig> <pubkey>, without the public key itself.
+	p2shP2WPKHSigScriptSize = 1 + 22     // <OP_0 <20-byte hash>>
+	p2wpkhWitnessSize       = 1 + 1 + 72 + 1 + 33
+	p2trKeyWitnessSize      = 1 + 1 + 64 // Schnorr signature with the default sighash.
+)
+
+// inputWeight returns the weight of an input spending an output locked by
+// script, and whether the input has a witness. P2PKH outputs are spent with
+// an uncompressed public key if uncompressed is set. Of the P2SH outputs,
+// only nested P2WPKH ones are supported. P2TR outputs are spent through
+// leaf, or by key path if it is nil.
+func inputWeight(script []byte, leaf *tapLeaf, uncompressed bool) (int, bool, error) {
+	switch txscript.GetScriptClass(script) {
+	case txscript.PubKeyHashTy:
+		pubKeySize := compressedPubKeySize
+		if uncompressed {
+			pubKeySize = uncompressedPubKeySize
+		}
+		return txInBaseWeight + witnessScaleFactor*(p2pkhSigScriptSize+pubKeySize), false, nil
+	case txscript.ScriptHashTy:
+		return txInBaseWeight + witnessScaleFactor*p2shP2WPKHSigScriptSize + p2wpkhWitnessSize, true, nil
+	case txscript.WitnessV0PubKeyHashTy:
+		return txInBaseWeight + p2wpkhWitnessSize, true, nil
+	case txscript.WitnessV1TaprootTy:
+		if leaf != nil {
+			return txInBaseWeight + leaf.witnessSize(), true, nil
+		}
+		return txInBaseWeight + p2trKeyWitnessSize, true, nil
+	default:
+		return 0, false, fmt.Errorf("cannot estimate the size of an input spending %x", script)
+	}
+}
+
+// outputWeight returns the weight of an output with script.
+func outputWeight(script []byte) int {
+	return witnessScaleFactor * (8 + wire.VarIntSerializeSize(uint64(len(script))) + len(script))
+}
+
+// sizeEstimate accumulates the weight of a transaction before it is signed.
+type sizeEstimate struct {
+	weight  int
+	inputs  int
+	outputs int
+	legacy  int // Inputs without a witness.
+	witness bool
+}
+
+func newSizeEstimate() *sizeEstimate {
+	return &sizeEstimate{weight: txOverheadWeight}
+}
+
+// addInput adds an input spending an output locked by script, through leaf
+// if it is a P2TR output spent by script path, and with an uncompressed
+// public key if uncompressed is set.
+func (e *sizeEstimate) addInput(script []byte, leaf *tapLeaf, uncompressed bool) error {
+	w, witness, err := inputWeight(script, leaf, uncompressed)
+	if err != nil {
+		return err
+	}
+	e.weight += w
+	e.inputs++
+	if witness {
+		e.witness = true
+	} else {
+		e.legacy++
+	}
+	return nil
+}
+
+// addOutput adds an output with script.
+func (e *sizeEstimate) addOutput(script []byte) {
+	e.weight += outputWeight(script)
+	e.outputs++
+}
+
+// vsize returns the virtual size of the transaction.
+func (e *sizeEstimate) vsize() int {
+	w := e.weight + witnessScaleFactor*(wire.VarIntSerializeSize(uint64(e.inputs))+wire.VarIntSerializeSize(uint64(e.outputs)))
+	if e.witness {
+		// Inputs without a witness still serialize an empty one.
+		w += segwitMarkerWeight + e.legacy
+	}
+	return vbytes(w)
+}
+
+// vbytes converts weight to virtual bytes, rounding up.
+func vbytes(weight int) int {
+	return (weight + witnessScaleFactor - 1) / witnessScaleFactor
+}
+
+// coinVSize returns the virtual size of an input spending c, rounded up.
+func coinVSize(c Coin) (int, error) {
+	script, err := hex.DecodeString(c.ScriptPubKey)
+	if err != nil {
+		return 0, err
+	}
+	w, _, err := inputWeight(script, c.tapLeaf, c.uncompressed)
+	if err != nil {
+		return 0, err
+	}
+	return vbytes(w), nil
+}
+
+// Fee estimation modes of estimatesmartfee.
+var estimateModes = []string{"economical", "conservative"}
+
+// estimateFeeRate asks the node for the fee rate that confirms a transaction
+// within target blocks.
+func estimateFeeRate(client *rpcclient.Client, target int64, mode string) (FeeRate, error) {
+	m := btcjson.EstimateSmartFeeMode(strings.ToUpper(mode))
+	res, err := client.EstimateSmartFee(target, &m)
+	if err != nil {
+		return 0, fmt.Errorf("estimatesmartfee: %w", err)
+	}
+	if res.FeeRate == nil {
+		return 0, fmt.Errorf("estimatesmartfee: no estimate for %d blocks (%s), set a fee rate instead",
+			target, strings.Join(res.Errors, "; "))
+	}
+	// The node answers in BTC per 1000 vbytes.
+	return FeeRate(*res.FeeRate * btcutil.SatoshiPerBitcoin / 1000), nil
+}
+
+// checkFee refuses fees above ceiling, which usually come from a mistyped
+// or absurd fee rate.
+func checkFee(fee, ceiling btc.Amount) error {
+	if fee > ceiling {
+		return fmt.Errorf("fee %s exceeds the ceiling of %s", fee, ceiling)
+	}
+	return nil
+}
@@ -2,114 +2,112 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/config"
+	"bitcoin-playground/network/btcdnet"
+	"bitcoin-playground/rpc"
 
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 )
 
-// Testnet RPC Config
-const (
-	rpcHost = "localhost:48332" // Testnet default RPC port
-	rpcUser = "admin"
-	rpcPass = "admin"
-)
-
-// Wallet and UTXO Details (Manually Extracted from listunspent)
+// Transaction details
 const (
 
 	// Recipient address
-	// This is the address that will receive the funds
+	// This is the address that will receive the funds, unless -pay lists others
 	recipient = "mt7Wd4k9KSs6f7XtAZY96JTsPfxmZLWNMN"
 
-	/*
-		Please read the README.md file to understand how to extract the UTXO details
-	*/
+	// Address receiving the change
+	changeAddress = "muCmmr3fwCvbFbdPUgtw6KFyx92qtDyuyx"
 
-	// Address that owns the UTXO
-	addressStr    = "muCmmr3fwCvbFbdPUgtw6KFyx92qtDyuyx"
-	privateKeyWIF = "XXXXXXXXXXXXXXXXXXXXXXXXXXXXX"
-
-	// UTXO details (we use the one with enough funds)
-	utxoTxID     = "ab9a228797321d42486449895a5689a353eee963006344526e2f0c85fce2bf0a"
-	utxoVout     = 0
-	scriptPubKey = "76a91496217dc748df395162630a1692fa685b4d66e44188ac"
-	utxoAmount   = 14872 // 0.00014872 BTC in Satoshis
-
-	// Transaction details
-	amountToSend = 10000 // Sending 10,000 Satoshis (0.0001 BTC)
-	fee          = 1000  // Transaction fee (1,000 Satoshis)
+	amountToSend btc.Amount = 10000 // Sending 10,000 Satoshis (0.0001 BTC)
+	dustLimit    btc.Amount = 546   // Outputs below this are rejected by the network
 )
 
-// Connect to Bitcoin RPC
-func connectRPC() (*rpcclient.Client, error) {
-	connCfg := &rpcclient.ConnConfig{
-		Host:         rpcHost,
-		User:         rpcUser,
-		Pass:         rpcPass,
-		HTTPPostMode: true,
-		DisableTLS:   true,
-	}
-	return rpcclient.New(connCfg, nil)
+/*
+	Please read the README.md file to understand how to extract the private keys
+*/
+
+// Private keys of the addresses whose UTXOs may be spent
+var privateKeysWIF = []string{
+	"XXXXXXXXXXXXXXXXXXXXXXXXXXXXX",
 }
 
-func prepareTx() (*wire.MsgTx, error) {
-	tx := wire.NewMsgTx(wire.TxVersion)
-	txHash, err := chainhash.NewHashFromStr(utxoTxID)
-	if err != nil {
-		return nil, err
-	}
-	outPoint := wire.NewOutPoint(txHash, utxoVout)
-	tx.AddTxIn(wire.NewTxIn(outPoint, nil, nil))
+// activeNetParams are the btcd parameters of the network selected with --network.
+var activeNetParams = &chaincfg.TestNet4Params
 
-	recipientAddr, err := btcutil.DecodeAddress(recipient, &chaincfg.TestNet4Params)
+// addressScript returns the output script paying addr, which must belong to
+// the active network.
+func addressScript(addr string) ([]byte, error) {
+	decoded, err := btcutil.DecodeAddress(addr, activeNetParams)
 	if err != nil {
 		return nil, err
 	}
-	pkScript, err := txscript.PayToAddrScript(recipientAddr)
-	if err != nil {
-		return nil, err
+	if !decoded.IsForNet(activeNetParams) {
+		return nil, fmt.Errorf("%s is not a %s address", addr, activeNetParams.Name)
 	}
-	tx.AddTxOut(wire.NewTxOut(amountToSend, pkScript))
+	return txscript.PayToAddrScript(decoded)
+}
 
-	change := utxoAmount - (amountToSend + fee)
-	if change > 546 {
-		changeAddr, err := btcutil.DecodeAddress(addressStr, &chaincfg.TestNet4Params)
-		if err != nil {
-			return nil, err
+// prepareTx completes b, which holds the payments, with the inputs and
+// change of sel, and builds it. The inputs get sequence if it is set. It
+// returns the coins spent in the order of tx.TxIn.
+func prepareTx(b *TxBuilder, sel Selection, sequence *uint32) (*wire.MsgTx, []Coin, error) {
+	for _, in := range sel.Inputs {
+		if sequence != nil {
+			b.AddInputWithSequence(in, *sequence)
+		} else {
+			b.AddInput(in)
 		}
-		changeScript, err := txscript.PayToAddrScript(changeAddr)
-		if err != nil {
-			return nil, err
+	}
+	if sel.Change > 0 {
+		if err := b.AddOutput(changeAddress, sel.Change); err != nil {
+			return nil, nil, err
 		}
-		tx.AddTxOut(wire.NewTxOut(int64(change), changeScript))
+	}
+	tx, inputs, err := b.Build()
+	if err != nil {
+		return nil, nil, err
 	}
 
 	m, _ := json.Marshal(tx)
 	fmt.Printf("Prepared Transaction:\n%s\n", m)
 
-	return tx, nil
+	return tx, inputs, nil
 }
 
-func signTx(tx *wire.MsgTx) (string, error) {
-	wif, err := btcutil.DecodeWIF(privateKeyWIF)
+// signTx signs each input of tx with the key of the coin it spends; inputs
+// are in the order of tx.TxIn. P2PKH inputs get a signature script, P2WPKH
+// and P2TR inputs a witness and P2SH-P2WPKH inputs both.
+func signTx(tx *wire.MsgTx, inputs []Coin, keys keyring) (string, error) {
+	prevOuts, err := prevOutFetcher(tx, inputs)
 	if err != nil {
-		return "", fmt.Errorf("error decoding WIF: %w", err)
+		return "", err
 	}
-	scriptPubKeyBytes, _ := hex.DecodeString(scriptPubKey)
-	for i, txIn := range tx.TxIn {
-		sigScript, err := txscript.SignatureScript(tx, i, scriptPubKeyBytes, txscript.SigHashAll, wif.PrivKey, true)
-		if err != nil {
+	sigHashes := txscript.NewTxSigHashes(tx, prevOuts)
+	for i := range tx.TxIn {
+		key, ok := keys[inputs[i].ScriptPubKey]
+		if !ok {
+			return "", fmt.Errorf("no private key for input %d (%s)", i, inputs[i].Address)
+		}
+		if err := signInput(tx, i, inputs[i], key, prevOuts, sigHashes); err != nil {
 			return "", err
 		}
-		txIn.SignatureScript = sigScript
 	}
 
 	var buf bytes.Buffer
@@ -118,6 +116,9 @@ func signTx(tx *wire.MsgTx) (string, error) {
 	}
 	signedTxHex := hex.EncodeToString(buf.Bytes())
 	fmt.Println("Signed Transaction Hex:", signedTxHex)
+	// The txid leaves out witnesses and the wtxid covers them; they only
+	// differ when segwit inputs are spent.
+	fmt.Printf("TxID: %s\nWTxID: %s\nSize: %d vB\n", tx.TxHash(), tx.WitnessHash(), txVSize(tx))
 	return signedTxHex, nil
 }
 
@@ -143,19 +144,147 @@ func broadcastTx(client *rpcclient.Client, signedTxHex string) error {
 	return nil
 }
 
+// printSelection summarizes the coins chosen to fund the transaction.
+func printSelection(sel Selection, strategy Strategy) {
+	fmt.Printf("Selected %d input(s) with %s:\n", len(sel.Inputs), strategy)
+	for _, in := range sel.Inputs {
+		fmt.Printf("  %s:%d  %s  %s\n", in.TxID, in.Vout, in.Address, in.Amount)
+	}
+	fmt.Printf("Estimated size: %d vB, fee: %s, change: %s\n", sel.VSize, sel.Fee, sel.Change)
+}
+
 func main() {
-	client, err := connectRPC()
+	indexerURL := flag.String("indexer", "", "base URL of the poc-indexing HTTP API to take UTXOs from instead of the wallet")
+	strategy := flag.String("coin-selection", string(Auto), fmt.Sprintf("coin selection strategy: %v", Strategies))
+	singleAddress := flag.Bool("single-address", false, "spend the UTXOs of a single address only, for privacy")
+	feeRateFlag := flag.Float64("fee-rate", 0, "fee rate in sat/vB; estimated with estimatesmartfee when 0")
+	confTarget := flag.Int64("conf-target", 6, "confirmation target in blocks for estimatesmartfee")
+	estimateMode := flag.String("estimate-mode", "conservative", fmt.Sprintf("estimatesmartfee mode: %v", estimateModes))
+	tapscripts := flag.String("tapscript", "", "comma-separated LEAF:CONTROLBLOCK pairs, in hex, to spend P2TR outputs by script path")
+	maxFee := flag.Int64("max-fee", 100000, "refuse to send transactions paying more than this fee, in satoshis")
+	pay := flag.String("pay", "", "comma-separated ADDRESS=AMOUNT payments to batch, instead of amountToSend to recipient; amounts are in BTC unless they carry a unit, e.g. 6000sat")
+	opReturn := flag.String("op-return", "", "text to embed in an OP_RETURN output (up to 80 bytes)")
+	var lockTime uint32
+	flag.Func("locktime", "block height, or Unix time from 500000000 on, before which the transaction cannot be mined", func(s string) error {
+		v, err := strconv.ParseUint(s, 0, 32)
+		if err != nil {
+			return err
+		}
+		lockTime = uint32(v)
+		return nil
+	})
+	var sequence *uint32
+	flag.Func("sequence", "nSequence of every input (default final, or 0xfffffffe with -locktime)", func(s string) error {
+		v, err := strconv.ParseUint(s, 0, 32)
+		if err != nil {
+			return err
+		}
+		seq := uint32(v)
+		sequence = &seq
+		return nil
+	})
+	bip69 := flag.Bool("bip69", false, "sort inputs and outputs as in BIP69 instead of keeping payments first and change last")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+
+	client, err := btcdnet.Connect(cfg.Node)
 	if err != nil {
 		log.Fatalf("Error connecting to Bitcoin RPC: %v", err)
 	}
 	defer client.Shutdown()
 
-	tx, err := prepareTx()
+	params, err := cfg.NetParams()
+	if err != nil {
+		log.Fatalf("Invalid network: %v", err)
+	}
+	if err := btcdnet.CheckNetwork(client, params); err != nil {
+		log.Fatalf("Network mismatch: %v", err)
+	}
+	activeNetParams = btcdnet.ChainParams(params)
+
+	var leaves []string
+	if *tapscripts != "" {
+		leaves = strings.Split(*tapscripts, ",")
+	}
+	keys, err := newKeyring(privateKeysWIF, leaves)
+	if err != nil {
+		log.Fatalf("Invalid private key: %v", err)
+	}
+
+	ctx := context.Background()
+	var coins []Coin
+	if *indexerURL != "" {
+		coins, err = indexerCoins(ctx, *indexerURL, keys.addresses())
+	} else {
+		var wallet *rpc.Client
+		if wallet, err = rpc.NewEndpointClient(cfg.Wallet); err == nil {
+			coins, err = walletCoins(ctx, wallet, keys.addresses())
+		}
+	}
+	if err != nil {
+		log.Fatalf("Error listing UTXOs: %v", err)
+	}
+
+	feeRate := FeeRate(*feeRateFlag)
+	switch {
+	case feeRate < 0:
+		log.Fatalf("Invalid fee rate: %s", feeRate)
+	case feeRate == 0:
+		if !slices.Contains(estimateModes, *estimateMode) {
+			log.Fatalf("Invalid estimate mode %q, expected one of %v", *estimateMode, estimateModes)
+		}
+		if feeRate, err = estimateFeeRate(client, *confTarget, *estimateMode); err != nil {
+			log.Fatalf("Error estimating fee rate: %v", err)
+		}
+		fmt.Printf("Estimated fee rate for %d blocks (%s): %s\n", *confTarget, *estimateMode, feeRate)
+	}
+
+	payments := []Payment{{Address: recipient, Amount: amountToSend}}
+	if *pay != "" {
+		if payments, err = parsePayments(*pay); err != nil {
+			log.Fatalf("Invalid payments: %v", err)
+		}
+	}
+	builder := NewTxBuilder()
+	builder.LockTime = lockTime
+	builder.BIP69 = *bip69
+	if err := builder.AddPayments(payments); err != nil {
+		log.Fatalf("Invalid payment: %v", err)
+	}
+	if *opReturn != "" {
+		if err := builder.AddOpReturn([]byte(*opReturn)); err != nil {
+			log.Fatalf("Invalid OP_RETURN data: %v", err)
+		}
+	}
+
+	changeScript, err := addressScript(changeAddress)
+	if err != nil {
+		log.Fatalf("Invalid change address: %v", err)
+	}
+	sel, err := selectCoins(keys.spendable(coins), SelectionParams{
+		Target:        builder.outputTotal(),
+		Outputs:       builder.outputScripts(),
+		Change:        changeScript,
+		FeeRate:       feeRate,
+		Strategy:      Strategy(*strategy),
+		SingleAddress: *singleAddress,
+	})
+	if err != nil {
+		log.Fatalf("Error selecting coins: %v", err)
+	}
+	printSelection(sel, Strategy(*strategy))
+	if err := checkFee(sel.Fee, btc.Amount(*maxFee)); err != nil {
+		log.Fatalf("Refusing to send: %v", err)
+	}
+
+	tx, inputs, err := prepareTx(builder, sel, sequence)
 	if err != nil {
 		log.Fatalf("Error preparing transaction: %v", err)
 	}
 
-	signedTxHex, err := signTx(tx)
+	signedTxHex, err := signTx(tx, inputs, keys)
 	if err != nil {
 		log.Fatalf("Error signing transaction: %v", err)
 	}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// prevOutFetcher returns the outputs spent by tx, which segwit sighashes
+// commit to; inputs are in the order of tx.TxIn.
+func prevOutFetcher(tx *wire.MsgTx, inputs []Coin) (*txscript.MultiPrevOutFetcher, error) {
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, in := range inputs {
+		script, err := hex.DecodeString(in.ScriptPubKey)
+		if err != nil {
+			return nil, err
+		}
+		fetcher.AddPrevOut(tx.TxIn[i].PreviousOutPoint, wire.NewTxOut(int64(in.Amount), script))
+	}
+	return fetcher, nil
+}
+
+// signInput fills in the signature script or witness of input i of tx,
+// which spends coin, and checks it against the script of coin.
+func signInput(tx *wire.MsgTx, i int, coin Coin, key signingKey, prevOuts txscript.PrevOutputFetcher, sigHashes *txscript.TxSigHashes) error {
+	wif := key.WIF
+	script, err := hex.DecodeString(coin.ScriptPubKey)
+	if err != nil {
+		return err
+	}
+	txIn := tx.TxIn[i]
+	amount := int64(coin.Amount)
+	switch txscript.GetScriptClass(script) {
+	case txscript.PubKeyHashTy:
+		txIn.SignatureScript, err = txscript.SignatureScript(tx, i, script, txscript.SigHashAll, wif.PrivKey, wif.CompressPubKey)
+	case txscript.WitnessV0PubKeyHashTy:
+		txIn.Witness, err = txscript.WitnessSignature(tx, sigHashes, i, amount, script, txscript.SigHashAll, wif.PrivKey, true)
+	case txscript.ScriptHashTy:
+		// Nested P2WPKH: the signature script pushes the witness program
+		// the script hash commits to, and the witness signs for it.
+		var redeemScript []byte
+		if redeemScript, err = p2wpkhScript(wif); err != nil {
+			return err
+		}
+		if txIn.SignatureScript, err = txscript.NewScriptBuilder().AddData(redeemScript).Script(); err != nil {
+			return err
+		}
+		txIn.Witness, err = txscript.WitnessSignature(tx, sigHashes, i, amount, redeemScript, txscript.SigHashAll, wif.PrivKey, true)
+	case txscript.WitnessV1TaprootTy:
+		// BIP341 sighashes commit to the amounts and scripts of all the
+		// outputs spent, which sigHashes was computed from.
+		if key.tapLeaf != nil {
+			txIn.Witness, err = key.tapLeaf.witness(tx, i, amount, script, wif, sigHashes)
+		} else {
+			txIn.Witness, err = txscript.TaprootWitnessSignature(tx, sigHashes, i, amount, script, txscript.SigHashDefault, wif.PrivKey)
+		}
+	default:
+		return fmt.Errorf("cannot sign input %d: unsupported script %x", i, script)
+	}
+	if err != nil {
+		return err
+	}
+
+	vm, err := txscript.NewEngine(script, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, amount, prevOuts)
+	if err != nil {
+		return err
+	}
+	if err := vm.Execute(); err != nil {
+		return fmt.Errorf("input %d does not verify: %w", i, err)
+	}
+	return nil
+}
+
+// p2wpkhScript returns the P2WPKH script of the public key of wif.
+func p2wpkhScript(wif *btcutil.WIF) ([]byte, error) {
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(wif.SerializePubKey()), activeNetParams)
+	if err != nil {
+		return nil, err
+	}
+	return txscript.PayToAddrScript(addr)
+}
+
+// txVSize returns the virtual size of tx: its weight, counting witness bytes
+// once and the others four times, divided by four.
+func txVSize(tx *wire.MsgTx) int {
+	weight := tx.SerializeSizeStripped()*(witnessScaleFactor-1) + tx.SerializeSize()
+	return vbytes(weight)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"bitcoin-playground/btc"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// testKey derives a private key of the active network from seed.
+func testKey(t *testing.T, seed string, compressed bool) *btcutil.WIF {
+	t.Helper()
+	h := sha256.Sum256([]byte(seed))
+	priv, _ := btcec.PrivKeyFromBytes(h[:])
+	wif, err := btcutil.NewWIF(priv, activeNetParams, compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wif
+}
+
+// testTapscript returns a "LEAF:CONTROLBLOCK" tapscript spending a P2TR
+// output with an unknown internal key through <key> OP_CHECKSIG.
+func testTapscript(t *testing.T, key *btcutil.WIF) string {
+	t.Helper()
+	script, err := txscript.NewScriptBuilder().
+		AddData(schnorr.SerializePubKey(key.PrivKey.PubKey())).
+		AddOp(txscript.OP_CHECKSIG).
+		Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal := testKey(t, "internal", true).PrivKey.PubKey()
+	tree := txscript.AssembleTaprootScriptTree(txscript.NewBaseTapLeaf(script))
+	cb := tree.LeafMerkleProofs[0].ToControlBlock(internal)
+	controlBlock, err := cb.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(script) + ":" + hex.EncodeToString(controlBlock)
+}
+
+// keyScripts returns the scripts keys unlocks with wif, by kind.
+func keyScripts(keys keyring, wif *btcutil.WIF) map[string]string {
+	scripts := make(map[string]string)
+	for script, key := range keys {
+		if !key.PrivKey.Key.Equals(&wif.PrivKey.Key) || key.CompressPubKey != wif.CompressPubKey {
+			continue
+		}
+		b, _ := hex.DecodeString(script)
+		kind := txscript.GetScriptClass(b).String()
+		if key.tapLeaf != nil {
+			kind += " script path"
+		}
+		scripts[kind] = script
+	}
+	return scripts
+}
+
+func TestNewKeyring(t *testing.T) {
+	compressed, uncompressed := testKey(t, "compressed", true), testKey(t, "uncompressed", false)
+	keys, err := newKeyring([]string{compressed.String(), uncompressed.String()}, []string{testTapscript(t, compressed)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 6 {
+		t.Errorf("keyring has %d scripts, want 6", len(keys))
+	}
+	for wif, want := range map[*btcutil.WIF][]string{
+		uncompressed: {"pubkeyhash"},
+		compressed:   {"pubkeyhash", "witness_v0_keyhash", "scripthash", "witness_v1_taproot", "witness_v1_taproot script path"},
+	} {
+		for _, kind := range want {
+			if _, ok := keyScripts(keys, wif)[kind]; !ok {
+				t.Errorf("no %s script for key %s", kind, wif)
+			}
+		}
+	}
+
+	mainnet, err := btcutil.NewWIF(compressed.PrivKey, &chaincfg.MainNetParams, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, args := range map[string][2][]string{
+		"mainnet key":      {{mainnet.String()}, nil},
+		"invalid WIF":      {{"not a key"}, nil},
+		"tapscript signer": {{uncompressed.String()}, {testTapscript(t, compressed)}},
+		"tapscript format": {{compressed.String()}, {"51"}},
+	} {
+		if _, err := newKeyring(args[0], args[1]); err == nil {
+			t.Errorf("%s: newKeyring succeeded", name)
+		}
+	}
+}
+
+func TestSignTx(t *testing.T) {
+	compressed, uncompressed := testKey(t, "compressed", true), testKey(t, "uncompressed", false)
+	keys, err := newKeyring([]string{compressed.String(), uncompressed.String()}, []string{testTapscript(t, compressed)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts := keyScripts(keys, compressed)
+	scripts["uncompressed pubkeyhash"] = keyScripts(keys, uncompressed)["pubkeyhash"]
+
+	// One input of each kind, with their size estimated as coin selection does.
+	var coins []Coin
+	for kind, script := range scripts {
+		coins = append(coins, Coin{
+			TxID:         strings.Repeat("ab", 32),
+			Vout:         uint32(len(coins)),
+			Address:      kind,
+			ScriptPubKey: script,
+			Amount:       btc.Amount(10_000 * (len(coins) + 1)),
+		})
+	}
+	coins = keys.spendable(coins)
+	b := NewTxBuilder()
+	b.BIP69 = true
+	est := newSizeEstimate()
+	for _, c := range coins {
+		b.AddInput(c)
+		script, _ := hex.DecodeString(c.ScriptPubKey)
+		if err := est.addInput(script, c.tapLeaf, c.uncompressed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.AddOutput(recipient, 50_000); err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range b.outputScripts() {
+		est.addOutput(script)
+	}
+	tx, inputs, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signTx(tx, inputs, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the inputs again, each against the outputs of all of them.
+	prevOuts, err := prevOutFetcher(tx, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigHashes := txscript.NewTxSigHashes(tx, prevOuts)
+	for i, in := range inputs {
+		script, _ := hex.DecodeString(in.ScriptPubKey)
+		vm, err := txscript.NewEngine(script, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, int64(in.Amount), prevOuts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := vm.Execute(); err != nil {
+			t.Errorf("input %d (%s) does not verify: %v", i, in.Address, err)
+		}
+		txIn := tx.TxIn[i]
+		hasScript, hasWitness := len(txIn.SignatureScript) > 0, len(txIn.Witness) > 0
+		switch txscript.GetScriptClass(script) {
+		case txscript.PubKeyHashTy:
+			if !hasScript || hasWitness {
+				t.Errorf("input %d (%s): want a signature script only", i, in.Address)
+			}
+		case txscript.ScriptHashTy:
+			if !hasScript || !hasWitness {
+				t.Errorf("input %d (%s): want a signature script and a witness", i, in.Address)
+			}
+		default:
+			if hasScript || !hasWitness {
+				t.Errorf("input %d (%s): want a witness only", i, in.Address)
+			}
+		}
+	}
+
+	// Estimates count signatures at their largest size, so they may exceed
+	// the signed size a little but never fall short.
+	if got, estimated := txVSize(tx), est.vsize(); got > estimated || estimated-got > len(inputs) {
+		t.Errorf("signed size %d vB, estimated %d vB", got, estimated)
+	}
+}
+
+func TestSizeEstimateCounts(t *testing.T) {
+	key := testKey(t, "counts", true)
+	keys, err := newKeyring([]string{key.String()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coin := keys.spendable([]Coin{{
+		TxID:         strings.Repeat("ab", 32),
+		Address:      "key",
+		ScriptPubKey: keyScripts(keys, key)["witness_v0_keyhash"],
+		Amount:       1_000_000,
+	}})[0]
+	script, _ := hex.DecodeString(coin.ScriptPubKey)
+
+	// 300 outputs take a 3-byte count.
+	b := NewTxBuilder()
+	b.AddInput(coin)
+	est := newSizeEstimate()
+	if err := est.addInput(script, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 300; i++ {
+		if err := b.AddOutput(recipient, dustLimit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, script := range b.outputScripts() {
+		est.addOutput(script)
+	}
+	tx, inputs, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signTx(tx, inputs, keys); err != nil {
+		t.Fatal(err)
+	}
+	if got, estimated := txVSize(tx), est.vsize(); got > estimated || estimated-got > 1 {
+		t.Errorf("signed size %d vB, estimated %d vB", got, estimated)
+	}
+}
+
+func TestSignTxErrors(t *testing.T) {
+	key, other := testKey(t, "key", true), testKey(t, "other", true)
+	keys, err := newKeyring([]string{key.String()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := keyScripts(keys, key)["witness_v0_keyhash"]
+	coin := Coin{TxID: strings.Repeat("ab", 32), Address: "key", ScriptPubKey: script, Amount: 20_000}
+	build := func() (*wire.MsgTx, []Coin) {
+		t.Helper()
+		b := NewTxBuilder()
+		b.AddInput(coin)
+		if err := b.AddOutput(recipient, 10_000); err != nil {
+			t.Fatal(err)
+		}
+		tx, inputs, err := b.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx, inputs
+	}
+
+	tx, inputs := build()
+	if _, err := signTx(tx, inputs, keyring{}); err == nil || !strings.Contains(err.Error(), "no private key") {
+		t.Errorf("signing without the key: error %v", err)
+	}
+
+	// A signature of another key fails the check of the script.
+	tx, inputs = build()
+	wrong := keyring{script: signingKey{WIF: other}}
+	if _, err := signTx(tx, inputs, wrong); err == nil || !strings.Contains(err.Error(), "does not verify") {
+		t.Errorf("signing with another key: error %v", err)
+	}
+
+	tx, inputs = build()
+	inputs[0].ScriptPubKey = "6a"
+	if _, err := signTx(tx, inputs, keyring{"6a": signingKey{WIF: key}}); err == nil || !strings.Contains(err.Error(), "unsupported script") {
+		t.Errorf("signing an OP_RETURN input: error %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// tapLeaf is the script path a P2TR output is spent through: a tapscript
+// leaf and the control block proving it is committed to by the output key.
+type tapLeaf struct {
+	leaf         txscript.TapLeaf
+	controlBlock []byte
+}
+
+// parseTapLeaf parses "LEAF:CONTROLBLOCK", both hex, and returns the leaf
+// with the P2TR script of the output it spends.
+func parseTapLeaf(s string) (*tapLeaf, []byte, error) {
+	leafHex, controlHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, nil, fmt.Errorf("tapscript %q: expected LEAF:CONTROLBLOCK", s)
+	}
+	script, err := hex.DecodeString(leafHex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tapscript leaf: %w", err)
+	}
+	controlBlock, err := hex.DecodeString(controlHex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("control block: %w", err)
+	}
+	cb, err := txscript.ParseControlBlock(controlBlock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("control block: %w", err)
+	}
+	outputKey := txscript.ComputeTaprootOutputKey(cb.InternalKey, cb.RootHash(script))
+	pkScript, err := txscript.PayToTaprootScript(outputKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tapLeaf{leaf: txscript.NewTapLeaf(cb.LeafVersion, script), controlBlock: controlBlock}, pkScript, nil
+}
+
+// signer returns the key among wifs whose x-only public key the leaf pushes,
+// such as the key of <pubkey> OP_CHECKSIG.
+func (l *tapLeaf) signer(wifs []*btcutil.WIF) (*btcutil.WIF, error) {
+	for _, wif := range wifs {
+		push := append([]byte{txscript.OP_DATA_32}, schnorr.SerializePubKey(wif.PrivKey.PubKey())...)
+		if bytes.Contains(l.leaf.Script, push) {
+			return wif, nil
+		}
+	}
+	return nil, fmt.Errorf("tapscript %x does not use any of the private keys", l.leaf.Script)
+}
+
+// witnessSize returns the size of the witness spending through l with a
+// single signature.
+func (l *tapLeaf) witnessSize() int {
+	size := 1 + 1 + schnorr.SignatureSize
+	for _, item := range [][]byte{l.leaf.Script, l.controlBlock} {
+		size += wire.VarIntSerializeSize(uint64(len(item))) + len(item)
+	}
+	return size
+}
+
+// witness signs input i of tx, which spends a P2TR output locked by script,
+// through l. The leaf must be satisfied by that signature alone.
+func (l *tapLeaf) witness(tx *wire.MsgTx, i int, amount int64, script []byte, wif *btcutil.WIF, sigHashes *txscript.TxSigHashes) (wire.TxWitness, error) {
+	sig, err := txscript.RawTxInTapscriptSignature(tx, sigHashes, i, amount, script, l.leaf, txscript.SigHashDefault, wif.PrivKey)
+	if err != nil {
+		return nil, err
+	}
+	return wire.TxWitness{sig, l.leaf.Script, l.controlBlock}, nil
+}
@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+)
+
+// Batch collects calls that are sent to the server in a single HTTP request.
+// A Batch is not safe for concurrent use.
+type Batch struct {
+	client *Client
+	calls  []*BatchCall
+}
+
+// BatchCall is one request of a Batch. Err and the value behind Result are
+// populated by Batch.Send.
+type BatchCall struct {
+	Method string
+	Params []interface{}
+	Result interface{}
+	Err    error
+
+	id uint64
+}
+
+// NewBatch starts an empty batch.
+func (c *Client) NewBatch() *Batch {
+	return &Batch{client: c}
+}
+
+// Add queues a call whose result will be decoded into result (which may be nil).
+func (b *Batch) Add(method string, params []interface{}, result interface{}) *BatchCall {
+	call := &BatchCall{Method: method, Params: params, Result: result}
+	b.calls = append(b.calls, call)
+	return call
+}
+
+// Len returns the number of queued calls.
+func (b *Batch) Len() int {
+	return len(b.calls)
+}
+
+// Send posts all queued calls at once and correlates the responses by ID.
+// The returned error only reports transport failures; per-call errors are
+// stored in BatchCall.Err.
+func (b *Batch) Send(ctx context.Context) error {
+	if len(b.calls) == 0 {
+		return nil
+	}
+	reqs := make([]Request, len(b.calls))
+	byID := make(map[uint64]*BatchCall, len(b.calls))
+	for i, call := range b.calls {
+		reqs[i] = b.client.newRequest(call.Method, call.Params)
+		call.id = reqs[i].ID
+		byID[call.id] = call
+	}
+
+	var resps []Response
+	if err := b.client.post(ctx, reqs, &resps); err != nil && len(resps) == 0 {
+		return err
+	}
+
+	answered := make(map[uint64]bool, len(resps))
+	for _, resp := range resps {
+		call, ok := byID[resp.ID]
+		if !ok || answered[resp.ID] {
+			continue
+		}
+		answered[resp.ID] = true
+		call.Err = resp.decode(call.Method, call.Result)
+	}
+	for _, call := range b.calls {
+		if !answered[call.id] {
+			call.Err = fmt.Errorf("%s: no response in batch", call.Method)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitcoin-playground/rpc"
+)
+
+// reply is one response of a batch, as the stand-in node sends it.
+type reply struct {
+	ID     uint64        `json:"id"`
+	Result interface{}   `json:"result"`
+	Error  *rpc.RPCError `json:"error"`
+}
+
+// newBatchNode starts a stand-in node that answers a batch with the replies
+// respond returns for it. It returns the URL of the node.
+func newBatchNode(t *testing.T, respond func(reqs []rpc.Request) []reply) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []rpc.Request
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			t.Errorf("decode batch: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(respond(reqs))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// echoHeight answers getblockhash with the height it was asked for.
+func echoHeight(req rpc.Request) reply {
+	return reply{ID: req.ID, Result: req.Params[0]}
+}
+
+// queueHeights adds getblockhash calls for heights 0 to n-1 to b.
+func queueHeights(b *rpc.Batch, n int) ([]*rpc.BatchCall, []int64) {
+	calls := make([]*rpc.BatchCall, n)
+	results := make([]int64, n)
+	for i := range calls {
+		calls[i] = b.Add("getblockhash", []interface{}{i}, &results[i])
+	}
+	return calls, results
+}
+
+func TestBatchOutOfOrderReplies(t *testing.T) {
+	url := newBatchNode(t, func(reqs []rpc.Request) []reply {
+		replies := make([]reply, len(reqs))
+		for i, req := range reqs {
+			replies[len(reqs)-1-i] = echoHeight(req)
+		}
+		return replies
+	})
+	batch := rpc.New(rpc.Config{Endpoint: url}).NewBatch()
+	calls, results := queueHeights(batch, 4)
+	if batch.Len() != 4 {
+		t.Fatalf("Len = %d, want 4", batch.Len())
+	}
+
+	if err := batch.Send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i, call := range calls {
+		if call.Err != nil {
+			t.Errorf("call %d: %v", i, call.Err)
+		}
+		if results[i] != int64(i) {
+			t.Errorf("call %d got the result %d of another call", i, results[i])
+		}
+	}
+}
+
+func TestBatchMissingReply(t *testing.T) {
+	url := newBatchNode(t, func(reqs []rpc.Request) []reply {
+		// The second call gets no reply; an unknown ID and a duplicate of the
+		// first reply are thrown in and must be ignored.
+		return []reply{
+			echoHeight(reqs[0]),
+			{ID: reqs[2].ID + 100, Result: 42},
+			echoHeight(reqs[2]),
+			{ID: reqs[0].ID, Result: 99},
+		}
+	})
+	batch := rpc.New(rpc.Config{Endpoint: url}).NewBatch()
+	calls, results := queueHeights(batch, 3)
+
+	if err := batch.Send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls[0].Err != nil || results[0] != 0 {
+		t.Errorf("call 0: %d, %v; want 0, nil", results[0], calls[0].Err)
+	}
+	if calls[1].Err == nil || !strings.Contains(calls[1].Err.Error(), "no response") {
+		t.Errorf("call 1 error %v, want a missing response", calls[1].Err)
+	}
+	if calls[2].Err != nil || results[2] != 2 {
+		t.Errorf("call 2: %d, %v; want 2, nil", results[2], calls[2].Err)
+	}
+}
+
+func TestBatchPerCallErrors(t *testing.T) {
+	url := newBatchNode(t, func(reqs []rpc.Request) []reply {
+		replies := make([]reply, len(reqs))
+		for i, req := range reqs {
+			replies[i] = reply{ID: req.ID, Result: "ok"}
+			if req.Method == "getrawtransaction" {
+				replies[i] = reply{ID: req.ID, Error: &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: "No such mempool transaction"}}
+			}
+		}
+		return replies
+	})
+	batch := rpc.New(rpc.Config{Endpoint: url}).NewBatch()
+	var hash string
+	found := batch.Add("getblockhash", []interface{}{0}, &hash)
+	missing := batch.Add("getrawtransaction", []interface{}{"aa", true}, nil)
+
+	if err := batch.Send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if found.Err != nil || hash != "ok" {
+		t.Errorf("getblockhash: %q, %v", hash, found.Err)
+	}
+	if !errors.Is(missing.Err, rpc.ErrTxNotFound) {
+		t.Errorf("getrawtransaction error %v, want ErrTxNotFound", missing.Err)
+	}
+}
+
+func TestBatchTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	batch := rpc.New(rpc.Config{Endpoint: srv.URL}).NewBatch()
+	queueHeights(batch, 2)
+
+	if err := batch.Send(context.Background()); !errors.Is(err, rpc.ErrUnauthorized) {
+		t.Errorf("Send error %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestBatchEmpty(t *testing.T) {
+	url := newBatchNode(t, func([]rpc.Request) []reply {
+		t.Error("empty batch was sent")
+		return nil
+	})
+	if err := rpc.New(rpc.Config{Endpoint: url}).NewBatch().Send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
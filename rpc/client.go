@@ -0,0 +1,136 @@
+// Package rpc is a small JSON-RPC client for bitcoind and btcwallet.
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Config holds the connection settings of a Client.
+type Config struct {
+	// Endpoint is the full URL of the node or wallet, e.g. "http://127.0.0.1:48332/".
+	Endpoint string
+	User     string
+	Pass     string
+
+	// HTTPClient is used for all requests. http.DefaultClient is used when nil.
+	HTTPClient *http.Client
+}
+
+// Client sends JSON-RPC requests to a single endpoint.
+type Client struct {
+	cfg    Config
+	http   *http.Client
+	nextID atomic.Uint64
+}
+
+// Request defines the JSON-RPC request structure.
+type Request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// Response defines the JSON-RPC response structure.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      uint64          `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *RPCError       `json:"error"`
+}
+
+// New returns a Client for the given configuration.
+func New(cfg Config) *Client {
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{cfg: cfg, http: httpClient}
+}
+
+// Call invokes method with params and decodes the result into result.
+// A nil result discards the response payload.
+func (c *Client) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	req := c.newRequest(method, params)
+	var resp Response
+	err := c.post(ctx, req, &resp)
+	// bitcoind answers RPC errors with HTTP 500 and a JSON body, so the
+	// decoded error is more useful than the status code.
+	if resp.Error == nil && err != nil {
+		return err
+	}
+	return resp.decode(method, result)
+}
+
+// newRequest builds a request with a fresh ID.
+func (c *Client) newRequest(method string, params []interface{}) Request {
+	if params == nil {
+		params = []interface{}{}
+	}
+	return Request{
+		JSONRPC: "1.0",
+		ID:      c.nextID.Add(1),
+		Method:  method,
+		Params:  params,
+	}
+}
+
+// decode returns the error of resp, or unmarshals its result into result.
+func (resp Response) decode(method string, result interface{}) error {
+	if resp.Error != nil {
+		resp.Error.Method = method
+		return resp.Error
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("%s: unexpected result: %w", method, err)
+	}
+	return nil
+}
+
+// post sends payload to the endpoint and decodes the JSON body into out.
+// A non-200 status is reported as *HTTPError even if the body was decoded.
+func (c *Client) post(ctx context.Context, payload, out interface{}) error {
+	reqBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.Endpoint, bytes.NewReader(reqBytes))
+	if err != nil {
+		return err
+	}
+	httpReq.SetBasicAuth(c.cfg.User, c.cfg.Pass)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := c.http.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	var statusErr error
+	if httpResp.StatusCode != http.StatusOK {
+		statusErr = &HTTPError{StatusCode: httpResp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		if statusErr != nil {
+			return statusErr
+		}
+		return err
+	}
+	return statusErr
+}
@@ -0,0 +1,194 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/rpc"
+)
+
+// handlerFunc answers one JSON-RPC request of a stand-in node.
+type handlerFunc func(req rpc.Request) (interface{}, *rpc.RPCError)
+
+// newNode starts a stand-in node that authenticates with user "u" and pass
+// "p" and answers single requests with handle, using HTTP 500 for errors as
+// bitcoind does. It returns the URL of the node.
+func newNode(t *testing.T, handle handlerFunc) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "u" || pass != "p" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req rpc.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		result, rpcErr := handle(req)
+		status := http.StatusOK
+		if rpcErr != nil {
+			status = http.StatusInternalServerError
+		}
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "result": result, "error": rpcErr})
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// newClient returns a client of the node at url with the right credentials.
+func newClient(url string) *rpc.Client {
+	return rpc.New(rpc.Config{Endpoint: url, User: "u", Pass: "p"})
+}
+
+func TestCallDecodesResult(t *testing.T) {
+	var got rpc.Request
+	client := newClient(newNode(t, func(req rpc.Request) (interface{}, *rpc.RPCError) {
+		got = req
+		return []map[string]interface{}{{"txid": "aa", "vout": 1, "amount": 0.0001, "spendable": true}}, nil
+	}))
+
+	unspent, err := client.ListUnspent(context.Background(), "mzBc4XEFSdzCDcTxAgf6EZXgsZWpztRhef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rpc.Unspent{{TxID: "aa", Vout: 1, Amount: 10_000 * btc.Satoshi, Spendable: true}}
+	if !reflect.DeepEqual(unspent, want) {
+		t.Errorf("ListUnspent = %+v, want %+v", unspent, want)
+	}
+	if got.Method != "listunspent" || len(got.Params) != 3 {
+		t.Errorf("sent %s %v, want listunspent with 3 params", got.Method, got.Params)
+	}
+}
+
+func TestCallIDsIncrease(t *testing.T) {
+	var ids []uint64
+	client := newClient(newNode(t, func(req rpc.Request) (interface{}, *rpc.RPCError) {
+		ids = append(ids, req.ID)
+		return 1, nil
+	}))
+	for range 3 {
+		if _, err := client.GetBlockCount(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids[0] >= ids[1] || ids[1] >= ids[2] {
+		t.Errorf("request ids %v are not increasing", ids)
+	}
+}
+
+func TestCallNilResultDiscardsPayload(t *testing.T) {
+	client := newClient(newNode(t, func(rpc.Request) (interface{}, *rpc.RPCError) {
+		return map[string]string{"name": "w"}, nil
+	}))
+	if err := client.CreateWallet(context.Background(), "w"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallUnexpectedResult(t *testing.T) {
+	client := newClient(newNode(t, func(rpc.Request) (interface{}, *rpc.RPCError) {
+		return "not a number", nil
+	}))
+	_, err := client.GetBlockCount(context.Background())
+	if err == nil {
+		t.Fatal("GetBlockCount succeeded with a string result")
+	}
+	var rpcErr *rpc.RPCError
+	if errors.As(err, &rpcErr) {
+		t.Errorf("decode failure reported as RPC error %v", err)
+	}
+}
+
+func TestRPCErrorSentinels(t *testing.T) {
+	tests := []struct {
+		method string
+		code   int
+		is     error
+		isNot  error
+	}{
+		{"walletpassphrase", rpc.CodeWalletUnlockNeeded, rpc.ErrWalletLocked, rpc.ErrInsufficientFunds},
+		{"sendtoaddress", rpc.CodeWalletInsufficientFunds, rpc.ErrInsufficientFunds, rpc.ErrWalletLocked},
+		{"getrawtransaction", rpc.CodeInvalidAddressOrKey, rpc.ErrTxNotFound, rpc.ErrInvalidAddress},
+		{"gettransaction", rpc.CodeInvalidAddressOrKey, rpc.ErrTxNotFound, rpc.ErrInvalidAddress},
+		// The same code means an invalid address outside the lookups.
+		{"dumpprivkey", rpc.CodeInvalidAddressOrKey, rpc.ErrInvalidAddress, rpc.ErrTxNotFound},
+		{"getblock", rpc.CodeInvalidAddressOrKey, rpc.ErrInvalidAddress, rpc.ErrTxNotFound},
+		{"getblockhash", rpc.CodeInvalidParameter, nil, rpc.ErrInvalidAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			client := newClient(newNode(t, func(rpc.Request) (interface{}, *rpc.RPCError) {
+				return nil, &rpc.RPCError{Code: tt.code, Message: "failed"}
+			}))
+			err := client.Call(context.Background(), tt.method, nil, nil)
+			var rpcErr *rpc.RPCError
+			if !errors.As(err, &rpcErr) {
+				t.Fatalf("error %v is not an *RPCError", err)
+			}
+			if rpcErr.Code != tt.code || rpcErr.Method != tt.method {
+				t.Errorf("got code %d of %q, want %d of %q", rpcErr.Code, rpcErr.Method, tt.code, tt.method)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.is)
+			}
+			if errors.Is(err, tt.isNot) {
+				t.Errorf("errors.Is(%v, %v) = true", err, tt.isNot)
+			}
+			if errors.Is(err, rpc.ErrUnauthorized) {
+				t.Errorf("RPC error %v reported as unauthorized", err)
+			}
+		})
+	}
+}
+
+func TestHTTPStatusErrors(t *testing.T) {
+	tests := []struct {
+		status       int
+		body         string
+		unauthorized bool
+	}{
+		{http.StatusUnauthorized, "", true},
+		{http.StatusForbidden, "Forbidden", true},
+		{http.StatusInternalServerError, "<html>oops</html>", false},
+		{http.StatusServiceUnavailable, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			client := rpc.New(rpc.Config{Endpoint: srv.URL})
+
+			_, err := client.GetBlockCount(context.Background())
+			var httpErr *rpc.HTTPError
+			if !errors.As(err, &httpErr) || httpErr.StatusCode != tt.status || httpErr.Body != tt.body {
+				t.Fatalf("error %#v, want *HTTPError with status %d and body %q", err, tt.status, tt.body)
+			}
+			if got := errors.Is(err, rpc.ErrUnauthorized); got != tt.unauthorized {
+				t.Errorf("errors.Is(%v, ErrUnauthorized) = %v, want %v", err, got, tt.unauthorized)
+			}
+		})
+	}
+}
+
+func TestWrongCredentials(t *testing.T) {
+	url := newNode(t, func(rpc.Request) (interface{}, *rpc.RPCError) {
+		t.Error("request with wrong credentials was handled")
+		return nil, nil
+	})
+	client := rpc.New(rpc.Config{Endpoint: url, User: "u", Pass: "wrong"})
+	if _, err := client.GetBlockCount(context.Background()); !errors.Is(err, rpc.ErrUnauthorized) {
+		t.Errorf("error %v, want ErrUnauthorized", err)
+	}
+}
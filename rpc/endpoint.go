@@ -0,0 +1,22 @@
+package rpc
+
+import "bitcoin-playground/config"
+
+// NewEndpointClient returns a client of the server described by e, with its
+// credentials and TLS settings.
+func NewEndpointClient(e config.Endpoint) (*Client, error) {
+	user, pass, err := e.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := NewHTTPClient(TLSOptions{
+		CAFile:   e.CAFile,
+		CertFile: e.CertFile,
+		KeyFile:  e.KeyFile,
+		Insecure: e.Insecure,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return New(Config{Endpoint: e.URL, User: user, Pass: pass, HTTPClient: httpClient}), nil
+}
@@ -0,0 +1,91 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Well-known bitcoind error codes, see rpc/protocol.h in Bitcoin Core.
+const (
+	CodeMiscError               = -1
+	CodeTypeError               = -3
+	CodeWalletError             = -4
+	CodeInvalidAddressOrKey     = -5
+	CodeWalletInsufficientFunds = -6
+	CodeInvalidParameter        = -8
+	CodeWalletUnlockNeeded      = -13
+	CodeWalletPassphraseWrong   = -14
+	CodeWalletWrongEncState     = -15
+	CodeDeserializationError    = -22
+	CodeVerifyError             = -25
+	CodeVerifyRejected          = -26
+	CodeVerifyAlreadyInChain    = -27
+	CodeMethodNotFound          = -32601
+	CodeParseError              = -32700
+)
+
+// Sentinel errors matched by RPCError via errors.Is.
+var (
+	ErrWalletLocked      = errors.New("wallet locked")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrTxNotFound        = errors.New("transaction not found")
+	ErrInvalidAddress    = errors.New("invalid address or key")
+	ErrUnauthorized      = errors.New("unauthorized: check rpc credentials")
+)
+
+// txLookupMethods report "not found" with CodeInvalidAddressOrKey.
+var txLookupMethods = map[string]bool{
+	"getrawtransaction": true,
+	"gettransaction":    true,
+}
+
+// RPCError is an error object returned in a JSON-RPC response.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+
+	// Method is the request method that failed. It is not part of the wire format.
+	Method string `json:"-"`
+}
+
+func (e *RPCError) Error() string {
+	if e.Method == "" {
+		return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s: rpc error %d: %s", e.Method, e.Code, e.Message)
+}
+
+// Is maps well-known codes onto the package sentinel errors.
+func (e *RPCError) Is(target error) bool {
+	switch target {
+	case ErrWalletLocked:
+		return e.Code == CodeWalletUnlockNeeded
+	case ErrInsufficientFunds:
+		return e.Code == CodeWalletInsufficientFunds
+	case ErrTxNotFound:
+		return e.Code == CodeInvalidAddressOrKey && txLookupMethods[e.Method]
+	case ErrInvalidAddress:
+		return e.Code == CodeInvalidAddressOrKey && !txLookupMethods[e.Method]
+	}
+	return false
+}
+
+// HTTPError is returned when the endpoint answers with a non-200 status
+// and no JSON-RPC error in the body.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("http status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("http status %d: %s", e.StatusCode, e.Body)
+}
+
+// Is reports authentication failures as ErrUnauthorized.
+func (e *HTTPError) Is(target error) bool {
+	return target == ErrUnauthorized && (e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
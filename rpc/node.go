@@ -0,0 +1,79 @@
+package rpc
+
+import "context"
+
+// GetRawTransaction retrieves a decoded transaction from the node.
+// Without blockHash only mempool (or txindex) transactions can be found.
+func (c *Client) GetRawTransaction(ctx context.Context, txid, blockHash string) (*RawTransaction, error) {
+	params := []interface{}{txid, true}
+	if blockHash != "" {
+		params = append(params, blockHash)
+	}
+	var tx RawTransaction
+	if err := c.Call(ctx, "getrawtransaction", params, &tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// GetBlock retrieves a block, with transaction ids, given its hash.
+func (c *Client) GetBlock(ctx context.Context, blockHash string) (*Block, error) {
+	var block Block
+	if err := c.Call(ctx, "getblock", []interface{}{blockHash}, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// GetBlockchainInfo returns the state of the node's chain, including which network it is on.
+func (c *Client) GetBlockchainInfo(ctx context.Context) (*BlockchainInfo, error) {
+	var info BlockchainInfo
+	if err := c.Call(ctx, "getblockchaininfo", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// GetBlockCount returns the height of the most-work chain.
+func (c *Client) GetBlockCount(ctx context.Context) (int64, error) {
+	var count int64
+	err := c.Call(ctx, "getblockcount", nil, &count)
+	return count, err
+}
+
+// GetBlockHash returns the hash of the block at height.
+func (c *Client) GetBlockHash(ctx context.Context, height int64) (string, error) {
+	var hash string
+	err := c.Call(ctx, "getblockhash", []interface{}{height}, &hash)
+	return hash, err
+}
+
+// GetRawMempool returns the txids of the transactions in the node's mempool.
+func (c *Client) GetRawMempool(ctx context.Context) ([]string, error) {
+	var txids []string
+	err := c.Call(ctx, "getrawmempool", nil, &txids)
+	return txids, err
+}
+
+// GetBlockHashes returns the hashes of the blocks from height from to to
+// (inclusive) using a single batched request.
+func (c *Client) GetBlockHashes(ctx context.Context, from, to int64) ([]string, error) {
+	if to < from {
+		return nil, nil
+	}
+	hashes := make([]string, to-from+1)
+	batch := c.NewBatch()
+	calls := make([]*BatchCall, len(hashes))
+	for i := range hashes {
+		calls[i] = batch.Add("getblockhash", []interface{}{from + int64(i)}, &hashes[i])
+	}
+	if err := batch.Send(ctx); err != nil {
+		return nil, err
+	}
+	for _, call := range calls {
+		if call.Err != nil {
+			return nil, call.Err
+		}
+	}
+	return hashes, nil
+}
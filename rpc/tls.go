@@ -0,0 +1,67 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// TLSOptions configures how an https endpoint is verified and how the client
+// authenticates to it.
+type TLSOptions struct {
+	// CAFile is a PEM bundle that replaces the system roots, e.g. btcwallet's
+	// self-signed rpc.cert.
+	CAFile string
+	// CertFile and KeyFile hold an optional client certificate.
+	CertFile string
+	KeyFile  string
+	// Insecure disables server certificate verification. Testing only.
+	Insecure bool
+}
+
+// NewHTTPClient returns an http.Client with a single pooled Transport
+// configured from opts. Reuse it across Clients talking to the same server.
+func NewHTTPClient(opts TLSOptions) (*http.Client, error) {
+	tlsCfg, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsCfg
+	return &http.Client{Transport: tr}, nil
+}
+
+// config builds the tls.Config described by opts.
+func (opts TLSOptions) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.Insecure,
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate requires both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,210 @@
+package rpc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bitcoin-playground/rpc"
+)
+
+// testCert is a generated certificate and its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newCert generates a certificate for 127.0.0.1 signed by parent, or a
+// self-signed CA if parent is nil.
+func newCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// tlsCert returns c as a tls.Certificate.
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// writePEM writes the certificate and key of c to dir and returns their paths.
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// newTLSNode starts an https stand-in node answering getblockcount with 7,
+// with a certificate signed by ca. If clientCA is not nil, clients must
+// present a certificate it signed.
+func newTLSNode(t *testing.T, ca, clientCA *testCert) string {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"result":7,"error":null,"id":1}`))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{newCert(t, "node", ca).tlsCert()}}
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.cert)
+		srv.TLS.ClientCAs = pool
+		srv.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// blockCount calls getblockcount at url through a client configured with opts.
+func blockCount(t *testing.T, url string, opts rpc.TLSOptions) (int64, error) {
+	t.Helper()
+	httpClient, err := rpc.NewHTTPClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rpc.New(rpc.Config{Endpoint: url, HTTPClient: httpClient}).GetBlockCount(context.Background())
+}
+
+func TestTLSPinnedCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	url := newTLSNode(t, ca, nil)
+
+	count, err := blockCount(t, url, rpc.TLSOptions{CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Errorf("getblockcount = %d, want 7", count)
+	}
+}
+
+func TestTLSRejectsUntrustedCert(t *testing.T) {
+	dir := t.TempDir()
+	other := newCert(t, "other ca", nil)
+	otherFile, _ := other.writePEM(t, dir, "other")
+	url := newTLSNode(t, newCert(t, "ca", nil), nil)
+
+	for name, opts := range map[string]rpc.TLSOptions{
+		"system roots": {},
+		"other CA":     {CAFile: otherFile},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := blockCount(t, url, opts)
+			var unknown x509.UnknownAuthorityError
+			if !errors.As(err, &unknown) {
+				t.Errorf("error %v, want an unknown authority error", err)
+			}
+		})
+	}
+
+	if _, err := blockCount(t, url, rpc.TLSOptions{Insecure: true}); err != nil {
+		t.Errorf("insecure client: %v", err)
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "ca", nil)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	clientCA := newCert(t, "client ca", nil)
+	certFile, keyFile := newCert(t, "client", clientCA).writePEM(t, dir, "client")
+	url := newTLSNode(t, ca, clientCA)
+
+	if _, err := blockCount(t, url, rpc.TLSOptions{CAFile: caFile}); err == nil {
+		t.Error("connected without a client certificate")
+	}
+	// A certificate the node does not trust is refused as well.
+	strayCert, strayKey := newCert(t, "stray", nil).writePEM(t, dir, "stray")
+	if _, err := blockCount(t, url, rpc.TLSOptions{CAFile: caFile, CertFile: strayCert, KeyFile: strayKey}); err == nil {
+		t.Error("connected with an untrusted client certificate")
+	}
+	count, err := blockCount(t, url, rpc.TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Errorf("getblockcount = %d, want 7", count)
+	}
+}
+
+func TestTLSOptionErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newCert(t, "client", nil).writePEM(t, dir, "client")
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certificate here\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts rpc.TLSOptions
+		want string
+	}{
+		{"missing CA file", rpc.TLSOptions{CAFile: filepath.Join(dir, "missing.pem")}, "read CA file"},
+		{"CA file without certificates", rpc.TLSOptions{CAFile: empty}, "no certificates found"},
+		{"certificate without key", rpc.TLSOptions{CertFile: certFile}, "requires both"},
+		{"key without certificate", rpc.TLSOptions{KeyFile: keyFile}, "requires both"},
+		{"mismatched key", rpc.TLSOptions{CertFile: certFile, KeyFile: empty}, "load client certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := rpc.NewHTTPClient(tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewHTTPClient error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package rpc
+
+import "bitcoin-playground/btc"
+
+// WalletTransaction is the result of gettransaction.
+type WalletTransaction struct {
+	TxID            string                    `json:"txid"`
+	Amount          btc.Amount                `json:"amount"`
+	Fee             btc.Amount                `json:"fee,omitempty"`
+	Confirmations   int64                     `json:"confirmations"`
+	BlockHash       string                    `json:"blockhash,omitempty"`
+	BlockIndex      int64                     `json:"blockindex,omitempty"`
+	BlockTime       int64                     `json:"blocktime,omitempty"`
+	WalletConflicts []string                  `json:"walletconflicts"`
+	Time            int64                     `json:"time"`
+	TimeReceived    int64                     `json:"timereceived"`
+	Details         []WalletTransactionDetail `json:"details"`
+	Hex             string                    `json:"hex"`
+}
+
+// WalletTransactionDetail is one entry of WalletTransaction.Details.
+type WalletTransactionDetail struct {
+	Account  string     `json:"account,omitempty"`
+	Address  string     `json:"address,omitempty"`
+	Category string     `json:"category"`
+	Amount   btc.Amount `json:"amount"`
+	Vout     uint32     `json:"vout"`
+	Fee      btc.Amount `json:"fee,omitempty"`
+}
+
+// RawTransaction is the verbose result of getrawtransaction.
+type RawTransaction struct {
+	TxID          string `json:"txid"`
+	Hash          string `json:"hash"`
+	Version       int32  `json:"version"`
+	Size          int64  `json:"size"`
+	VSize         int64  `json:"vsize"`
+	Weight        int64  `json:"weight"`
+	LockTime      uint32 `json:"locktime"`
+	Vin           []Vin  `json:"vin"`
+	Vout          []Vout `json:"vout"`
+	Hex           string `json:"hex"`
+	BlockHash     string `json:"blockhash,omitempty"`
+	Confirmations int64  `json:"confirmations,omitempty"`
+	Time          int64  `json:"time,omitempty"`
+	BlockTime     int64  `json:"blocktime,omitempty"`
+}
+
+// Vin is a transaction input as reported by getrawtransaction.
+type Vin struct {
+	Coinbase    string     `json:"coinbase,omitempty"`
+	TxID        string     `json:"txid,omitempty"`
+	Vout        uint32     `json:"vout"`
+	ScriptSig   *ScriptSig `json:"scriptSig,omitempty"`
+	TxInWitness []string   `json:"txinwitness,omitempty"`
+	Sequence    uint32     `json:"sequence"`
+}
+
+// ScriptSig is the signature script of an input.
+type ScriptSig struct {
+	Asm string `json:"asm"`
+	Hex string `json:"hex"`
+}
+
+// Vout is a transaction output as reported by getrawtransaction.
+type Vout struct {
+	Value        btc.Amount   `json:"value"`
+	N            uint32       `json:"n"`
+	ScriptPubKey ScriptPubKey `json:"scriptPubKey"`
+}
+
+// ScriptPubKey is the locking script of an output.
+type ScriptPubKey struct {
+	Asm       string   `json:"asm"`
+	Hex       string   `json:"hex"`
+	Type      string   `json:"type"`
+	Address   string   `json:"address,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// Block is the result of getblock with verbosity 1.
+type Block struct {
+	Hash              string   `json:"hash"`
+	Confirmations     int64    `json:"confirmations"`
+	Size              int64    `json:"size"`
+	StrippedSize      int64    `json:"strippedsize"`
+	Weight            int64    `json:"weight"`
+	Height            int64    `json:"height"`
+	Version           int32    `json:"version"`
+	VersionHex        string   `json:"versionHex"`
+	MerkleRoot        string   `json:"merkleroot"`
+	Tx                []string `json:"tx"`
+	Time              int64    `json:"time"`
+	MedianTime        int64    `json:"mediantime"`
+	Nonce             uint32   `json:"nonce"`
+	Bits              string   `json:"bits"`
+	Difficulty        float64  `json:"difficulty"`
+	ChainWork         string   `json:"chainwork,omitempty"`
+	NTx               int64    `json:"nTx"`
+	PreviousBlockHash string   `json:"previousblockhash,omitempty"`
+	NextBlockHash     string   `json:"nextblockhash,omitempty"`
+}
+
+// Unspent is one entry of the listunspent result.
+type Unspent struct {
+	TxID          string     `json:"txid"`
+	Vout          uint32     `json:"vout"`
+	Address       string     `json:"address"`
+	Account       string     `json:"account,omitempty"`
+	ScriptPubKey  string     `json:"scriptPubKey"`
+	RedeemScript  string     `json:"redeemScript,omitempty"`
+	Amount        btc.Amount `json:"amount"`
+	Confirmations int64      `json:"confirmations"`
+	Spendable     bool       `json:"spendable"`
+}
+
+// BlockchainInfo is the result of getblockchaininfo.
+type BlockchainInfo struct {
+	Chain                string  `json:"chain"`
+	Blocks               int64   `json:"blocks"`
+	Headers              int64   `json:"headers"`
+	BestBlockHash        string  `json:"bestblockhash"`
+	Difficulty           float64 `json:"difficulty"`
+	MedianTime           int64   `json:"mediantime"`
+	VerificationProgress float64 `json:"verificationprogress"`
+	InitialBlockDownload bool    `json:"initialblockdownload"`
+	Pruned               bool    `json:"pruned"`
+}
@@ -0,0 +1,84 @@
+package rpc
+
+import (
+	"context"
+
+	"bitcoin-playground/btc"
+)
+
+// GetBalance queries the wallet balance for the given account (or "*" for all addresses).
+func (c *Client) GetBalance(ctx context.Context, account string, minConf int) (btc.Amount, error) {
+	if account == "" {
+		account = "*"
+	}
+	var balance btc.Amount
+	err := c.Call(ctx, "getbalance", []interface{}{account, minConf}, &balance)
+	return balance, err
+}
+
+// SendToAddress sends amount to address and returns the txid.
+func (c *Client) SendToAddress(ctx context.Context, address string, amount btc.Amount) (string, error) {
+	var txid string
+	err := c.Call(ctx, "sendtoaddress", []interface{}{address, amount}, &txid)
+	return txid, err
+}
+
+// GetTransaction retrieves a wallet transaction.
+func (c *Client) GetTransaction(ctx context.Context, txid string, includeWatchOnly bool) (*WalletTransaction, error) {
+	var tx WalletTransaction
+	if err := c.Call(ctx, "gettransaction", []interface{}{txid, includeWatchOnly}, &tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// WalletPassphrase unlocks the wallet for timeout seconds.
+func (c *Client) WalletPassphrase(ctx context.Context, passphrase string, timeout int) error {
+	return c.Call(ctx, "walletpassphrase", []interface{}{passphrase, timeout}, nil)
+}
+
+// GetNewAddress generates a new address, optionally under the given account.
+func (c *Client) GetNewAddress(ctx context.Context, account string) (string, error) {
+	var params []interface{}
+	if account != "" {
+		params = []interface{}{account}
+	}
+	var address string
+	err := c.Call(ctx, "getnewaddress", params, &address)
+	return address, err
+}
+
+// GetAddressesByAccount retrieves all addresses for a specific account.
+func (c *Client) GetAddressesByAccount(ctx context.Context, account string) ([]string, error) {
+	var params []interface{}
+	if account != "" {
+		params = []interface{}{account}
+	}
+	var addresses []string
+	err := c.Call(ctx, "getaddressesbyaccount", params, &addresses)
+	return addresses, err
+}
+
+// CreateWallet creates a new wallet with the given name.
+func (c *Client) CreateWallet(ctx context.Context, name string) error {
+	return c.Call(ctx, "createwallet", []interface{}{name}, nil)
+}
+
+// ListUnspent lists the wallet's unspent outputs, optionally filtered to a single address.
+func (c *Client) ListUnspent(ctx context.Context, address string) ([]Unspent, error) {
+	var params []interface{}
+	if address != "" {
+		params = []interface{}{0, 9999999, []string{address}}
+	}
+	var unspent []Unspent
+	err := c.Call(ctx, "listunspent", params, &unspent)
+	return unspent, err
+}
+
+// DumpPrivKey returns the WIF-encoded private key of address.
+// The wallet must be unlocked.
+func (c *Client) DumpPrivKey(ctx context.Context, address string) (string, error) {
+	var key string
+	err := c.Call(ctx, "dumpprivkey", []interface{}{address}, &key)
+	return key, err
+}
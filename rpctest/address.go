@@ -0,0 +1,44 @@
+package rpctest
+
+import (
+	"fmt"
+
+	"bitcoin-playground/network"
+	"bitcoin-playground/network/btcdnet"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// AddressScript returns the output script paying to addr on the network.
+func AddressScript(params *network.Params, addr string) ([]byte, error) {
+	decoded, err := btcutil.DecodeAddress(addr, btcdnet.ChainParams(params))
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", addr, err)
+	}
+	if !decoded.IsForNet(btcdnet.ChainParams(params)) {
+		return nil, fmt.Errorf("address %s is not a %s address", addr, params.Name)
+	}
+	return txscript.PayToAddrScript(decoded)
+}
+
+// ScriptAddress returns the address an output script pays to, or "" for
+// scripts without one. As in bitcoind, bare public keys and multisig
+// scripts have none.
+func ScriptAddress(params *network.Params, script []byte) string {
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(script, btcdnet.ChainParams(params))
+	if err != nil || len(addrs) != 1 {
+		return ""
+	}
+	switch class {
+	case txscript.PubKeyHashTy, txscript.ScriptHashTy, txscript.WitnessV0PubKeyHashTy,
+		txscript.WitnessV0ScriptHashTy, txscript.WitnessV1TaprootTy:
+		return addrs[0].EncodeAddress()
+	}
+	return ""
+}
+
+// scriptType names the type of an output script the way bitcoind does.
+func scriptType(script []byte) string {
+	return txscript.GetScriptClass(script).String()
+}
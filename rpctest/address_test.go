@@ -0,0 +1,85 @@
+package rpctest
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"bitcoin-playground/network"
+)
+
+// Addresses and their scripts from BIP173, BIP350 and mainnet.
+var addressVectors = []struct {
+	params *network.Params
+	addr   string
+	script string
+}{
+	{&network.Mainnet, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", "76a91477bff20c60e522dfaa3350c39b030a5d004e839a88ac"},
+	{&network.Mainnet, "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", "a914b472a266d0bd89c13706a4132ccfb16f7c3b9fcb87"},
+	{&network.Testnet4, "mzBc4XEFSdzCDcTxAgf6EZXgsZWpztRhef", "76a914ccc198c15d8344c73da67a75509a85a8f422663688ac"},
+	{&network.Testnet4, "2MzQwSSnBHWHqSAqtTVQ6v47XtaisrJa1Vc", "a9144e9f39ca4688ff102128ea4ccda34105324305b087"},
+	{&network.Mainnet, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+	{&network.Regtest, "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+	{&network.Testnet3, "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+	{&network.Testnet3, "tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+	{&network.Mainnet, "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+}
+
+func TestAddressScriptRoundTrip(t *testing.T) {
+	for _, v := range addressVectors {
+		t.Run(v.addr, func(t *testing.T) {
+			script, err := AddressScript(v.params, v.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(script); got != v.script {
+				t.Errorf("AddressScript = %s, want %s", got, v.script)
+			}
+			if got := ScriptAddress(v.params, script); got != v.addr {
+				t.Errorf("ScriptAddress = %s, want %s", got, v.addr)
+			}
+		})
+	}
+}
+
+func TestAddressScriptUppercaseBech32(t *testing.T) {
+	script, err := AddressScript(&network.Mainnet, strings.ToUpper("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(script); got != "0014751e76e8199196d454941c45d1b3a323f1433bd6" {
+		t.Errorf("AddressScript = %s", got)
+	}
+}
+
+func TestAddressScriptRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		addr string
+	}{
+		{"base58 checksum", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3"},
+		{"base58 character", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN0"},
+		{"other network", "mzBc4XEFSdzCDcTxAgf6EZXgsZWpztRhef"},
+		{"bech32 checksum", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5"},
+		{"mixed case", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kV8f3t4"},
+		// BIP350: v1 programs need bech32m and v0 programs bech32.
+		{"v1 with bech32", "bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7k7grplx"},
+		{"v0 with bech32m", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if script, err := AddressScript(&network.Mainnet, tt.addr); err == nil {
+				t.Errorf("AddressScript(%s) = %x, want an error", tt.addr, script)
+			}
+		})
+	}
+}
+
+func TestScriptAddressNonStandard(t *testing.T) {
+	for _, script := range []string{"", "51", "6a0568656c6c6f", "0014751e76e8"} {
+		b, _ := hex.DecodeString(script)
+		if addr := ScriptAddress(&network.Mainnet, b); addr != "" {
+			t.Errorf("ScriptAddress(%s) = %s, want none", script, addr)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package rpctest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// regtestBits is the compact difficulty target of regtest blocks.
+const regtestBits = 0x207fffff
+
+// Block is a block of the fake chain.
+type Block struct {
+	Height   int64
+	Version  int32
+	PrevHash string // empty for the genesis block
+	Time     int64
+	Nonce    uint32
+	Txs      []*Tx
+}
+
+// header returns the btcd form of the block header.
+func (b *Block) header() wire.BlockHeader {
+	h := wire.BlockHeader{
+		Version:    b.Version,
+		MerkleRoot: b.merkleRoot(),
+		Timestamp:  time.Unix(b.Time, 0),
+		Bits:       regtestBits,
+		Nonce:      b.Nonce,
+	}
+	if b.PrevHash != "" {
+		if prev, err := chainhash.NewHashFromStr(b.PrevHash); err == nil {
+			h.PrevBlock = *prev
+		}
+	}
+	return h
+}
+
+// Header returns the 80 byte block header.
+func (b *Block) Header() []byte {
+	var buf bytes.Buffer
+	h := b.header()
+	_ = h.Serialize(&buf)
+	return buf.Bytes()
+}
+
+// Hash returns the block hash.
+func (b *Block) Hash() string {
+	h := b.header()
+	return h.BlockHash().String()
+}
+
+// MerkleRoot returns the merkle root of the block's transaction ids.
+func (b *Block) MerkleRoot() string {
+	return b.merkleRoot().String()
+}
+
+func (b *Block) merkleRoot() chainhash.Hash {
+	if len(b.Txs) == 0 {
+		return chainhash.Hash{}
+	}
+	level := make([]chainhash.Hash, len(b.Txs))
+	for i, tx := range b.Txs {
+		level[i] = tx.msgTx().TxHash()
+	}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([]chainhash.Hash, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, chainhash.DoubleHashH(append(level[i][:], level[i+1][:]...)))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// msgBlock converts b to its btcd form.
+func (b *Block) msgBlock() *wire.MsgBlock {
+	msg := wire.NewMsgBlock(&wire.BlockHeader{})
+	msg.Header = b.header()
+	for _, tx := range b.Txs {
+		_ = msg.AddTransaction(tx.msgTx())
+	}
+	return msg
+}
+
+// Serialize encodes the block as returned by getblock with verbosity 0.
+func (b *Block) Serialize() []byte {
+	var buf bytes.Buffer
+	_ = b.msgBlock().Serialize(&buf)
+	return buf.Bytes()
+}
+
+func (b *Block) size() (size, stripped, weight int64) {
+	msg := b.msgBlock()
+	size, stripped = int64(msg.SerializeSize()), int64(msg.SerializeSizeStripped())
+	return size, stripped, stripped*3 + size
+}
+
+func bitsHex() string {
+	return fmt.Sprintf("%08x", regtestBits)
+}
+
+func versionHex(v int32) string {
+	return hex.EncodeToString([]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
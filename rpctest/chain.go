@@ -0,0 +1,612 @@
+package rpctest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/network"
+	"bitcoin-playground/network/btcdnet"
+	"bitcoin-playground/rpc"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+const (
+	// Subsidy is the value of every coinbase, plus the fees of its block.
+	Subsidy = 50 * btc.BTC
+	// DefaultPassphrase is the wallet passphrase of a new Chain, matching the
+	// default configuration of the tools.
+	DefaultPassphrase = "admin"
+
+	genesisTime   = 1_700_000_000
+	blockInterval = 600
+	// mempoolHeight is the height of coins that are not in a block yet.
+	mempoolHeight = -1
+	// walletFee is the fee paid by sendtoaddress.
+	walletFee = 1000 * btc.Satoshi
+	dustLimit = 546 * btc.Satoshi
+)
+
+// faucetScript (OP_TRUE) receives the coinbase outputs. Fund spends from it.
+var faucetScript = []byte{txscript.OP_TRUE}
+
+type outpoint struct {
+	txid string
+	vout uint32
+}
+
+// coin is an unspent output.
+type coin struct {
+	out      TxOut
+	height   int64
+	coinbase bool
+}
+
+// Chain is a scriptable in-memory block chain with a mempool and a single
+// wallet. All methods are safe for concurrent use.
+type Chain struct {
+	mu      sync.Mutex
+	params  *network.Params
+	blocks  []*Block          // the active chain, indexed by height
+	byHash  map[string]*Block // every block ever mined, including stale ones
+	mempool []*Tx             // in dependency order
+	txIndex bool
+	nonce   uint32 // makes every coinbase, and so every block, unique
+	wallet  wallet
+
+	listeners []Listener
+}
+
+// Listener is told about changes to a Chain. It is called with the chain
+// locked and must not call back into it.
+type Listener interface {
+	// BlockConnected is called for every block added to the active chain.
+	BlockConnected(b *Block)
+	// TxAccepted is called for every transaction added to the mempool.
+	TxAccepted(tx *Tx)
+}
+
+// NewChain returns a chain for the network holding only a genesis block.
+func NewChain(params *network.Params) *Chain {
+	c := &Chain{
+		params: params,
+		byHash: make(map[string]*Block),
+		wallet: wallet{
+			name:       "default",
+			accounts:   make(map[string]string),
+			keys:       make(map[string]string),
+			passphrase: DefaultPassphrase,
+		},
+	}
+	c.mineBlock()
+	return c
+}
+
+// Params returns the network of the chain.
+func (c *Chain) Params() *network.Params {
+	return c.params
+}
+
+// AddListener registers l for the changes made from now on.
+func (c *Chain) AddListener(l Listener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, l)
+}
+
+// SetTxIndex makes getrawtransaction find confirmed transactions without a
+// block hash, like bitcoind started with -txindex.
+func (c *Chain) SetTxIndex(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.txIndex = on
+}
+
+// Height returns the height of the tip.
+func (c *Chain) Height() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int64(len(c.blocks) - 1)
+}
+
+// Tip returns the last block of the active chain.
+func (c *Chain) Tip() *Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blocks[len(c.blocks)-1]
+}
+
+// BlockAt returns the block of the active chain at height, or nil.
+func (c *Chain) BlockAt(height int64) *Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if height < 0 || height >= int64(len(c.blocks)) {
+		return nil
+	}
+	return c.blocks[height]
+}
+
+// Mempool returns the unconfirmed transactions.
+func (c *Chain) Mempool() []*Tx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Tx(nil), c.mempool...)
+}
+
+// Mine appends n blocks to the chain. The first one confirms the mempool.
+func (c *Chain) Mine(n int) []*Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	mined := make([]*Block, n)
+	for i := range mined {
+		mined[i] = c.mineBlock()
+	}
+	return mined
+}
+
+func (c *Chain) mineBlock() *Block {
+	height := int64(len(c.blocks))
+	var fees btc.Amount
+	for _, tx := range c.mempool {
+		fees += c.fee(tx)
+	}
+	c.nonce++
+	script := make([]byte, 10)
+	script[0] = 4
+	binary.LittleEndian.PutUint32(script[1:], uint32(height))
+	script[5] = 4
+	binary.LittleEndian.PutUint32(script[6:], c.nonce)
+	coinbase := &Tx{
+		Version:  1,
+		Inputs:   []TxIn{{ScriptSig: script, Sequence: 0xffffffff}},
+		Outputs:  []TxOut{{Value: Subsidy + fees, Script: faucetScript}},
+		LockTime: 0,
+	}
+
+	b := &Block{
+		Height:  height,
+		Version: 0x20000000,
+		Time:    genesisTime + height*blockInterval,
+		Txs:     append([]*Tx{coinbase}, c.mempool...),
+	}
+	if height > 0 {
+		b.PrevHash = c.blocks[height-1].Hash()
+	}
+	c.mempool = nil
+	c.blocks = append(c.blocks, b)
+	c.byHash[b.Hash()] = b
+	for _, l := range c.listeners {
+		l.BlockConnected(b)
+	}
+	return b
+}
+
+// Reorg replaces the last depth blocks of the chain with n new ones, as if a
+// competing branch had overtaken it. Transactions of the disconnected blocks
+// return to the mempool unless they are no longer valid, and are confirmed
+// again by the first new block. It returns the disconnected blocks.
+func (c *Chain) Reorg(depth, n int) ([]*Block, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if depth < 1 || depth >= len(c.blocks) {
+		return nil, fmt.Errorf("reorg depth %d out of range 1-%d", depth, len(c.blocks)-1)
+	}
+	fork := len(c.blocks) - depth
+	disconnected := append([]*Block(nil), c.blocks[fork:]...)
+	c.blocks = c.blocks[:fork]
+
+	pending := c.mempool
+	c.mempool = nil
+	for _, b := range disconnected {
+		for _, tx := range b.Txs[1:] {
+			_, _ = c.addTx(tx)
+		}
+	}
+	for _, tx := range pending {
+		_, _ = c.addTx(tx)
+	}
+	for i := 0; i < n; i++ {
+		c.mineBlock()
+	}
+	return disconnected, nil
+}
+
+// AddTx validates tx against the chain and mempool and adds it to the
+// mempool. Signatures are not checked. A transaction spending outputs that
+// mempool transactions already spend replaces them if it pays a higher fee.
+func (c *Chain) AddTx(tx *Tx) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addTx(tx)
+}
+
+func (c *Chain) addTx(tx *Tx) (string, error) {
+	txid := tx.TxID()
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return "", rejected(rpc.CodeVerifyRejected, "bad-txns-vin-empty")
+	}
+	if tx.IsCoinbase() {
+		return "", rejected(rpc.CodeVerifyRejected, "coinbase")
+	}
+	if _, b, found := c.findTx(txid); found {
+		if b != nil {
+			return "", rejected(rpc.CodeVerifyAlreadyInChain, "Transaction already in block chain")
+		}
+		return "", rejected(rpc.CodeVerifyRejected, "txn-already-in-mempool")
+	}
+
+	conflicts := c.conflicts(tx)
+	view := c.coins(true, conflicts)
+	var in btc.Amount
+	seen := make(map[outpoint]bool)
+	for _, txIn := range tx.Inputs {
+		op := outpoint{txIn.PrevTxID, txIn.PrevVout}
+		if seen[op] {
+			return "", rejected(rpc.CodeVerifyRejected, "bad-txns-inputs-duplicate")
+		}
+		seen[op] = true
+		coin, ok := view[op]
+		if !ok {
+			return "", rejected(rpc.CodeVerifyError, "bad-txns-inputs-missingorspent")
+		}
+		in += coin.out.Value
+	}
+	var out btc.Amount
+	for _, txOut := range tx.Outputs {
+		if txOut.Value < 0 {
+			return "", rejected(rpc.CodeVerifyRejected, "bad-txns-vout-negative")
+		}
+		out += txOut.Value
+	}
+	if out > in {
+		return "", rejected(rpc.CodeVerifyRejected, "bad-txns-in-belowout")
+	}
+
+	if len(conflicts) > 0 {
+		var replaced btc.Amount
+		for _, m := range c.mempool {
+			if conflicts[m.TxID()] {
+				replaced += c.fee(m)
+			}
+		}
+		if in-out <= replaced {
+			return "", rejected(rpc.CodeVerifyRejected, "insufficient fee")
+		}
+		c.removeFromMempool(conflicts)
+	}
+	c.mempool = append(c.mempool, tx)
+	for _, l := range c.listeners {
+		l.TxAccepted(tx)
+	}
+	return txid, nil
+}
+
+// Evict drops a transaction and its descendants from the mempool, as if it
+// had expired. It reports whether the transaction was in the mempool.
+func (c *Chain) Evict(txid string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tx := range c.mempool {
+		if tx.TxID() == txid {
+			c.removeFromMempool(c.descendants(map[string]bool{txid: true}))
+			return true
+		}
+	}
+	return false
+}
+
+// Fund creates a mempool transaction paying amount to addr out of the mined
+// coins, mining blocks first when they are not enough. It returns the txid.
+func (c *Chain) Fund(addr string, amount btc.Amount) (string, error) {
+	script, err := AddressScript(c.params, addr)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		tx := &Tx{Version: 2}
+		var total btc.Amount
+		coins := c.coins(true, nil)
+		for _, op := range sortedOutpoints(coins) {
+			coin := coins[op]
+			if string(coin.out.Script) != string(faucetScript) {
+				continue
+			}
+			tx.Inputs = append(tx.Inputs, TxIn{PrevTxID: op.txid, PrevVout: op.vout, Sequence: 0xffffffff})
+			total += coin.out.Value
+			if total >= amount+walletFee {
+				break
+			}
+		}
+		if total < amount+walletFee {
+			c.mineBlock()
+			continue
+		}
+		tx.Outputs = append(tx.Outputs, TxOut{Value: amount, Script: script})
+		if change := total - amount - walletFee; change > 0 {
+			tx.Outputs = append(tx.Outputs, TxOut{Value: change, Script: faucetScript})
+		}
+		return c.addTx(tx)
+	}
+}
+
+// coins returns the unspent outputs of the active chain, and of the mempool
+// when mempool is set, ignoring the mempool transactions in exclude.
+func (c *Chain) coins(mempool bool, exclude map[string]bool) map[outpoint]coin {
+	view := make(map[outpoint]coin)
+	apply := func(tx *Tx, height int64) {
+		for _, in := range tx.Inputs {
+			if in.PrevTxID != "" {
+				delete(view, outpoint{in.PrevTxID, in.PrevVout})
+			}
+		}
+		txid := tx.TxID()
+		for i, out := range tx.Outputs {
+			view[outpoint{txid, uint32(i)}] = coin{out: out, height: height, coinbase: tx.IsCoinbase()}
+		}
+	}
+	for _, b := range c.blocks {
+		for _, tx := range b.Txs {
+			apply(tx, b.Height)
+		}
+	}
+	if mempool {
+		for _, tx := range c.mempool {
+			if !exclude[tx.TxID()] {
+				apply(tx, mempoolHeight)
+			}
+		}
+	}
+	return view
+}
+
+// sortedOutpoints returns the outpoints of coins, oldest first, so that coin
+// selection is deterministic.
+func sortedOutpoints(coins map[outpoint]coin) []outpoint {
+	ops := make([]outpoint, 0, len(coins))
+	for op := range coins {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		hi, hj := coins[ops[i]].height, coins[ops[j]].height
+		if hi == mempoolHeight {
+			hi = math.MaxInt64
+		}
+		if hj == mempoolHeight {
+			hj = math.MaxInt64
+		}
+		if hi != hj {
+			return hi < hj
+		}
+		if ops[i].txid != ops[j].txid {
+			return ops[i].txid < ops[j].txid
+		}
+		return ops[i].vout < ops[j].vout
+	})
+	return ops
+}
+
+// conflicts returns the mempool transactions double spent by tx, and their
+// descendants.
+func (c *Chain) conflicts(tx *Tx) map[string]bool {
+	spends := make(map[outpoint]bool)
+	for _, in := range tx.Inputs {
+		spends[outpoint{in.PrevTxID, in.PrevVout}] = true
+	}
+	direct := make(map[string]bool)
+	for _, m := range c.mempool {
+		for _, in := range m.Inputs {
+			if spends[outpoint{in.PrevTxID, in.PrevVout}] {
+				direct[m.TxID()] = true
+			}
+		}
+	}
+	return c.descendants(direct)
+}
+
+// descendants extends txids with the mempool transactions spending their outputs.
+func (c *Chain) descendants(txids map[string]bool) map[string]bool {
+	for _, m := range c.mempool {
+		for _, in := range m.Inputs {
+			if txids[in.PrevTxID] {
+				txids[m.TxID()] = true
+			}
+		}
+	}
+	return txids
+}
+
+func (c *Chain) removeFromMempool(txids map[string]bool) {
+	kept := c.mempool[:0]
+	for _, m := range c.mempool {
+		if !txids[m.TxID()] {
+			kept = append(kept, m)
+		}
+	}
+	c.mempool = kept
+}
+
+// findTx looks up a transaction in the active chain and the mempool. The
+// block is nil for mempool transactions.
+func (c *Chain) findTx(txid string) (*Tx, *Block, bool) {
+	for _, tx := range c.mempool {
+		if tx.TxID() == txid {
+			return tx, nil, true
+		}
+	}
+	for i := len(c.blocks) - 1; i >= 0; i-- {
+		for _, tx := range c.blocks[i].Txs {
+			if tx.TxID() == txid {
+				return tx, c.blocks[i], true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// prevOut returns the output spent by in.
+func (c *Chain) prevOut(in TxIn) (TxOut, bool) {
+	tx, _, found := c.findTx(in.PrevTxID)
+	if !found || int(in.PrevVout) >= len(tx.Outputs) {
+		return TxOut{}, false
+	}
+	return tx.Outputs[in.PrevVout], true
+}
+
+// fee returns the fee paid by a mempool or confirmed transaction.
+func (c *Chain) fee(tx *Tx) btc.Amount {
+	if tx.IsCoinbase() {
+		return 0
+	}
+	var fee btc.Amount
+	for _, in := range tx.Inputs {
+		if out, ok := c.prevOut(in); ok {
+			fee += out.Value
+		}
+	}
+	for _, out := range tx.Outputs {
+		fee -= out.Value
+	}
+	return fee
+}
+
+// confirmations returns the number of blocks confirming b, or -1 if b is not
+// in the active chain.
+func (c *Chain) confirmations(b *Block) int64 {
+	if b == nil {
+		return 0
+	}
+	if b.Height >= int64(len(c.blocks)) || c.blocks[b.Height] != b {
+		return -1
+	}
+	return int64(len(c.blocks)) - b.Height
+}
+
+// wallet is the single wallet of the fake node.
+type wallet struct {
+	name          string
+	accounts      map[string]string // address -> account
+	keys          map[string]string // address -> WIF private key
+	passphrase    string
+	unlockedUntil time.Time
+	next          int
+}
+
+// NewAddress adds a P2PKH address to the wallet under account. The wallet
+// does not know its private key.
+func (c *Chain) NewAddress(account string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.newAddress(account)
+}
+
+func (c *Chain) newAddress(account string) string {
+	c.wallet.next++
+	h := sha256.Sum256([]byte(fmt.Sprintf("rpctest %s %d", c.wallet.name, c.wallet.next)))
+	addr, _ := btcutil.NewAddressPubKeyHash(h[:20], btcdnet.ChainParams(c.params))
+	c.wallet.accounts[addr.EncodeAddress()] = account
+	return addr.EncodeAddress()
+}
+
+// ImportAddress adds addr to the wallet under account. wif is returned by
+// dumpprivkey and may be empty for watch-only addresses.
+func (c *Chain) ImportAddress(addr, account, wif string) error {
+	if _, err := AddressScript(c.params, addr); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wallet.accounts[addr] = account
+	if wif != "" {
+		c.wallet.keys[addr] = wif
+	}
+	return nil
+}
+
+// SetWalletPassphrase changes the passphrase walletpassphrase expects. An
+// empty passphrase makes the wallet unencrypted.
+func (c *Chain) SetWalletPassphrase(passphrase string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wallet.passphrase = passphrase
+	c.wallet.unlockedUntil = time.Time{}
+}
+
+// mine reports whether script pays to a wallet address, and returns it.
+func (c *Chain) mine(script []byte) (string, bool) {
+	addr := ScriptAddress(c.params, script)
+	if addr == "" {
+		return "", false
+	}
+	_, ok := c.wallet.accounts[addr]
+	return addr, ok
+}
+
+func (c *Chain) locked() bool {
+	return c.wallet.passphrase != "" && time.Now().After(c.wallet.unlockedUntil)
+}
+
+// walletCoins returns the wallet's unspent outputs, including unconfirmed ones.
+func (c *Chain) walletCoins() map[outpoint]coin {
+	coins := c.coins(true, nil)
+	for op, coin := range coins {
+		if _, ok := c.mine(coin.out.Script); !ok {
+			delete(coins, op)
+		}
+	}
+	return coins
+}
+
+// coinConfirmations returns the confirmations of a coin at height.
+func (c *Chain) coinConfirmations(height int64) int64 {
+	if height == mempoolHeight {
+		return 0
+	}
+	return int64(len(c.blocks)) - height
+}
+
+// send pays amount to addr out of the wallet's coins, sending the change to
+// a new wallet address.
+func (c *Chain) send(addr string, amount btc.Amount) (string, error) {
+	script, err := AddressScript(c.params, addr)
+	if err != nil {
+		return "", &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: "Invalid address"}
+	}
+	if c.locked() {
+		return "", &rpc.RPCError{Code: rpc.CodeWalletUnlockNeeded, Message: "Error: Please enter the wallet passphrase with walletpassphrase first."}
+	}
+
+	tx := &Tx{Version: 2}
+	var total btc.Amount
+	coins := c.walletCoins()
+	for _, op := range sortedOutpoints(coins) {
+		coin := coins[op]
+		tx.Inputs = append(tx.Inputs, TxIn{PrevTxID: op.txid, PrevVout: op.vout, Sequence: 0xfffffffd})
+		total += coin.out.Value
+		if total >= amount+walletFee {
+			break
+		}
+	}
+	if total < amount+walletFee {
+		return "", &rpc.RPCError{Code: rpc.CodeWalletInsufficientFunds, Message: "Insufficient funds"}
+	}
+	tx.Outputs = append(tx.Outputs, TxOut{Value: amount, Script: script})
+	if change := total - amount - walletFee; change >= dustLimit {
+		changeScript, _ := AddressScript(c.params, c.newAddress(""))
+		tx.Outputs = append(tx.Outputs, TxOut{Value: change, Script: changeScript})
+	}
+	return c.addTx(tx)
+}
+
+// rejected builds the error bitcoind returns for invalid transactions.
+func rejected(code int, reason string) error {
+	return &rpc.RPCError{Code: code, Message: reason}
+}
@@ -0,0 +1,390 @@
+package rpctest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/rpc"
+)
+
+// networkInfo is the part of getnetworkinfo that rpcclient needs to detect
+// the node version.
+type networkInfo struct {
+	Version         int32      `json:"version"`
+	SubVersion      string     `json:"subversion"`
+	ProtocolVersion int32      `json:"protocolversion"`
+	Connections     int        `json:"connections"`
+	NetworkActive   bool       `json:"networkactive"`
+	RelayFee        btc.Amount `json:"relayfee"`
+	IncrementalFee  btc.Amount `json:"incrementalfee"`
+	Warnings        string     `json:"warnings"`
+}
+
+// blockVerbose is the result of getblock with verbosity 2.
+type blockVerbose struct {
+	rpc.Block
+	Tx []rpc.RawTransaction `json:"tx"`
+}
+
+// scanUnspent is one output found by scantxoutset.
+type scanUnspent struct {
+	TxID         string     `json:"txid"`
+	Vout         uint32     `json:"vout"`
+	ScriptPubKey string     `json:"scriptPubKey"`
+	Desc         string     `json:"desc"`
+	Amount       btc.Amount `json:"amount"`
+	Coinbase     bool       `json:"coinbase"`
+	Height       int64      `json:"height"`
+	BlockHash    string     `json:"blockhash"`
+}
+
+// scanResult is the result of scantxoutset start.
+type scanResult struct {
+	Success     bool          `json:"success"`
+	TxOuts      int           `json:"txouts"`
+	Height      int64         `json:"height"`
+	BestBlock   string        `json:"bestblock"`
+	Unspents    []scanUnspent `json:"unspents"`
+	TotalAmount btc.Amount    `json:"total_amount"`
+}
+
+func getBlockchainInfo(c *Chain, _ args) (interface{}, error) {
+	tip := c.blocks[len(c.blocks)-1]
+	return rpc.BlockchainInfo{
+		Chain:                c.params.Chain,
+		Blocks:               tip.Height,
+		Headers:              tip.Height,
+		BestBlockHash:        tip.Hash(),
+		Difficulty:           1,
+		MedianTime:           tip.Time,
+		VerificationProgress: 1,
+	}, nil
+}
+
+func getNetworkInfo(*Chain, args) (interface{}, error) {
+	return networkInfo{
+		Version:         270000,
+		SubVersion:      "/Satoshi:27.0.0/",
+		ProtocolVersion: 70016,
+		NetworkActive:   true,
+		RelayFee:        1000 * btc.Satoshi,
+		IncrementalFee:  1000 * btc.Satoshi,
+	}, nil
+}
+
+func getBlockCount(c *Chain, _ args) (interface{}, error) {
+	return int64(len(c.blocks) - 1), nil
+}
+
+func getBlockHash(c *Chain, a args) (interface{}, error) {
+	var height int64
+	if err := a.require(0, &height); err != nil {
+		return nil, err
+	}
+	if height < 0 || height >= int64(len(c.blocks)) {
+		return nil, &rpc.RPCError{Code: rpc.CodeInvalidParameter, Message: "Block height out of range"}
+	}
+	return c.blocks[height].Hash(), nil
+}
+
+func getBlock(c *Chain, a args) (interface{}, error) {
+	b, err := c.blockParam(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	verbosity := 1
+	if _, err := a.get(1, &v); err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case bool:
+		if !v {
+			verbosity = 0
+		}
+	case float64:
+		verbosity = int(v)
+	}
+
+	switch verbosity {
+	case 0:
+		return hex.EncodeToString(b.Serialize()), nil
+	case 1:
+		return c.blockInfo(b), nil
+	}
+	result := blockVerbose{Block: c.blockInfo(b)}
+	for _, tx := range b.Txs {
+		result.Tx = append(result.Tx, c.rawTx(tx, b))
+	}
+	return result, nil
+}
+
+func getBlockHeader(c *Chain, a args) (interface{}, error) {
+	b, err := c.blockParam(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	verbose, err := a.flag(1, true)
+	if err != nil {
+		return nil, err
+	}
+	if !verbose {
+		return hex.EncodeToString(b.Header()), nil
+	}
+	info := c.blockInfo(b)
+	info.Tx = nil
+	return info, nil
+}
+
+func getRawTransaction(c *Chain, a args) (interface{}, error) {
+	var txid, blockHash string
+	if err := a.require(0, &txid); err != nil {
+		return nil, err
+	}
+	verbose, err := a.flag(1, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.get(2, &blockHash); err != nil {
+		return nil, err
+	}
+
+	var tx *Tx
+	var b *Block
+	if blockHash != "" {
+		if b, err = c.blockParam(a, 2); err != nil {
+			return nil, err
+		}
+		for _, t := range b.Txs {
+			if t.TxID() == txid {
+				tx = t
+			}
+		}
+		if tx == nil {
+			return nil, &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: "No such transaction found in the provided block. Use gettransaction for wallet transactions."}
+		}
+	} else {
+		var found bool
+		tx, b, found = c.findTx(txid)
+		if !found || (b != nil && !c.txIndex) {
+			return nil, &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: "No such mempool or blockchain transaction. Use gettransaction for wallet transactions."}
+		}
+	}
+
+	if !verbose {
+		return hex.EncodeToString(tx.Serialize(true)), nil
+	}
+	return c.rawTx(tx, b), nil
+}
+
+// getRawMempool only supports the txid list, not verbose entries.
+func getRawMempool(c *Chain, a args) (interface{}, error) {
+	verbose, err := a.flag(0, false)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		return nil, &rpc.RPCError{Code: rpc.CodeInvalidParameter, Message: "verbose getrawmempool is not supported"}
+	}
+	txids := []string{}
+	for _, tx := range c.mempool {
+		txids = append(txids, tx.TxID())
+	}
+	return txids, nil
+}
+
+func sendRawTransaction(c *Chain, a args) (interface{}, error) {
+	var txHex string
+	if err := a.require(0, &txHex); err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, &rpc.RPCError{Code: rpc.CodeDeserializationError, Message: "TX decode failed"}
+	}
+	tx, err := ParseTx(b)
+	if err != nil {
+		return nil, &rpc.RPCError{Code: rpc.CodeDeserializationError, Message: "TX decode failed: " + err.Error()}
+	}
+	return c.addTx(tx)
+}
+
+func scanTxOutSet(c *Chain, a args) (interface{}, error) {
+	var action string
+	if err := a.require(0, &action); err != nil {
+		return nil, err
+	}
+	switch action {
+	case "start":
+	case "abort":
+		return false, nil
+	case "status":
+		return nil, nil
+	default:
+		return nil, &rpc.RPCError{Code: rpc.CodeInvalidParameter, Message: fmt.Sprintf("Invalid action '%s'", action)}
+	}
+
+	var objects []json.RawMessage
+	if err := a.require(1, &objects); err != nil {
+		return nil, err
+	}
+	descs := make(map[string]string) // script -> descriptor
+	for _, obj := range objects {
+		var desc string
+		if err := json.Unmarshal(obj, &desc); err != nil {
+			var o struct {
+				Desc string `json:"desc"`
+			}
+			if err := json.Unmarshal(obj, &o); err != nil {
+				return nil, &rpc.RPCError{Code: rpc.CodeInvalidParameter, Message: "Scan object needs to be either a string or an object"}
+			}
+			desc = o.Desc
+		}
+		script, err := c.descriptorScript(desc)
+		if err != nil {
+			return nil, err
+		}
+		descs[string(script)] = desc
+	}
+
+	tip := c.blocks[len(c.blocks)-1]
+	view := c.coins(false, nil)
+	result := scanResult{
+		Success:   true,
+		TxOuts:    len(view),
+		Height:    tip.Height,
+		BestBlock: tip.Hash(),
+		Unspents:  []scanUnspent{},
+	}
+	for _, op := range sortedOutpoints(view) {
+		coin := view[op]
+		desc, ok := descs[string(coin.out.Script)]
+		if !ok {
+			continue
+		}
+		result.Unspents = append(result.Unspents, scanUnspent{
+			TxID:         op.txid,
+			Vout:         op.vout,
+			ScriptPubKey: hex.EncodeToString(coin.out.Script),
+			Desc:         desc,
+			Amount:       coin.out.Value,
+			Coinbase:     coin.coinbase,
+			Height:       coin.height,
+			BlockHash:    c.blocks[coin.height].Hash(),
+		})
+		result.TotalAmount += coin.out.Value
+	}
+	return result, nil
+}
+
+// descriptorScript resolves the addr() and raw() descriptors scantxoutset
+// supports here.
+func (c *Chain) descriptorScript(desc string) ([]byte, error) {
+	d, _, _ := strings.Cut(desc, "#")
+	switch {
+	case strings.HasPrefix(d, "addr(") && strings.HasSuffix(d, ")"):
+		script, err := AddressScript(c.params, d[len("addr("):len(d)-1])
+		if err != nil {
+			return nil, &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: err.Error()}
+		}
+		return script, nil
+	case strings.HasPrefix(d, "raw(") && strings.HasSuffix(d, ")"):
+		script, err := hex.DecodeString(d[len("raw(") : len(d)-1])
+		if err != nil {
+			return nil, &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: "Invalid raw script"}
+		}
+		return script, nil
+	}
+	return nil, &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: fmt.Sprintf("Unsupported descriptor %q, only addr() and raw() are supported", desc)}
+}
+
+// blockParam looks up the block whose hash is parameter i.
+func (c *Chain) blockParam(a args, i int) (*Block, error) {
+	var hash string
+	if err := a.require(i, &hash); err != nil {
+		return nil, err
+	}
+	b, ok := c.byHash[hash]
+	if !ok {
+		return nil, &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: "Block not found"}
+	}
+	return b, nil
+}
+
+func (c *Chain) blockInfo(b *Block) rpc.Block {
+	size, stripped, weight := b.size()
+	info := rpc.Block{
+		Hash:              b.Hash(),
+		Confirmations:     c.confirmations(b),
+		Size:              size,
+		StrippedSize:      stripped,
+		Weight:            weight,
+		Height:            b.Height,
+		Version:           b.Version,
+		VersionHex:        versionHex(b.Version),
+		MerkleRoot:        b.MerkleRoot(),
+		Time:              b.Time,
+		MedianTime:        b.Time,
+		Nonce:             b.Nonce,
+		Bits:              bitsHex(),
+		Difficulty:        1,
+		NTx:               int64(len(b.Txs)),
+		PreviousBlockHash: b.PrevHash,
+	}
+	if info.Confirmations > 1 {
+		info.NextBlockHash = c.blocks[b.Height+1].Hash()
+	}
+	for _, tx := range b.Txs {
+		info.Tx = append(info.Tx, tx.TxID())
+	}
+	return info
+}
+
+// rawTx describes tx as getrawtransaction does. b is nil for mempool transactions.
+func (c *Chain) rawTx(tx *Tx, b *Block) rpc.RawTransaction {
+	raw := rpc.RawTransaction{
+		TxID:     tx.TxID(),
+		Hash:     tx.WTxID(),
+		Version:  tx.Version,
+		Size:     int64(len(tx.Serialize(true))),
+		VSize:    tx.VSize(),
+		Weight:   tx.Weight(),
+		LockTime: tx.LockTime,
+		Hex:      hex.EncodeToString(tx.Serialize(true)),
+	}
+	for _, in := range tx.Inputs {
+		vin := rpc.Vin{Sequence: in.Sequence}
+		if in.PrevTxID == "" {
+			vin.Coinbase = hex.EncodeToString(in.ScriptSig)
+		} else {
+			vin.TxID = in.PrevTxID
+			vin.Vout = in.PrevVout
+			vin.ScriptSig = &rpc.ScriptSig{Hex: hex.EncodeToString(in.ScriptSig)}
+		}
+		for _, item := range in.Witness {
+			vin.TxInWitness = append(vin.TxInWitness, hex.EncodeToString(item))
+		}
+		raw.Vin = append(raw.Vin, vin)
+	}
+	for i, out := range tx.Outputs {
+		raw.Vout = append(raw.Vout, rpc.Vout{
+			Value: out.Value,
+			N:     uint32(i),
+			ScriptPubKey: rpc.ScriptPubKey{
+				Hex:     hex.EncodeToString(out.Script),
+				Type:    scriptType(out.Script),
+				Address: ScriptAddress(c.params, out.Script),
+			},
+		})
+	}
+	if b != nil {
+		raw.BlockHash = b.Hash()
+		raw.Confirmations = c.confirmations(b)
+		raw.Time = b.Time
+		raw.BlockTime = b.Time
+	}
+	return raw
+}
@@ -0,0 +1,206 @@
+// Package rpctest provides an in-process fake of the bitcoind (and btcwallet)
+// JSON-RPC interface, backed by a scriptable in-memory chain, so the CLI,
+// the indexer and the transaction POC can be exercised end to end offline.
+//
+//	chain := rpctest.NewChain(&network.Regtest)
+//	srv := rpctest.NewServer(chain, "admin", "admin")
+//	defer srv.Close()
+//	txid, _ := chain.Fund(addr, 10_000*btc.Satoshi)
+//	chain.Mine(1)
+//	// point --node-url and --wallet-url at srv.URL
+package rpctest
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"bitcoin-playground/rpc"
+)
+
+// Server serves a Chain over HTTP on a local port.
+type Server struct {
+	// URL is the endpoint to point the tools at, e.g. http://127.0.0.1:40123.
+	URL   string
+	Chain *Chain
+
+	user, pass string
+	http       *httptest.Server
+}
+
+// NewServer starts serving chain. Requests must authenticate with user and
+// pass unless user is empty.
+func NewServer(chain *Chain, user, pass string) *Server {
+	s := &Server{Chain: chain, user: user, pass: pass}
+	s.http = httptest.NewServer(s)
+	s.URL = s.http.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.http.Close()
+}
+
+// handlers implement the supported methods. They run with the chain locked.
+var handlers = map[string]func(*Chain, args) (interface{}, error){
+	// Node.
+	"getblockchaininfo":  getBlockchainInfo,
+	"getnetworkinfo":     getNetworkInfo,
+	"getblockcount":      getBlockCount,
+	"getblockhash":       getBlockHash,
+	"getblock":           getBlock,
+	"getblockheader":     getBlockHeader,
+	"getrawtransaction":  getRawTransaction,
+	"getrawmempool":      getRawMempool,
+	"sendrawtransaction": sendRawTransaction,
+	"scantxoutset":       scanTxOutSet,
+
+	// Wallet.
+	"createwallet":          createWallet,
+	"walletpassphrase":      walletPassphrase,
+	"getnewaddress":         getNewAddress,
+	"getaddressesbyaccount": getAddressesByAccount,
+	"getbalance":            getBalance,
+	"listunspent":           listUnspent,
+	"sendtoaddress":         sendToAddress,
+	"dumpprivkey":           dumpPrivKey,
+	"gettransaction":        getTransaction,
+}
+
+// request is a JSON-RPC request. Unlike rpc.Request it accepts any id.
+type request struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params args            `json:"params"`
+}
+
+// response always carries both result and error, as bitcoind's do.
+type response struct {
+	Result interface{}     `json:"result"`
+	Error  *rpc.RPCError   `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+// ServeHTTP answers single and batched JSON-RPC requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.user != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user+":"+pass), []byte(s.user+":"+s.pass)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		var reqs []json.RawMessage
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			writeJSON(w, http.StatusInternalServerError, parseError(err))
+			return
+		}
+		resps := make([]response, len(reqs))
+		for i, raw := range reqs {
+			resps[i] = s.handle(raw)
+		}
+		writeJSON(w, http.StatusOK, resps)
+		return
+	}
+
+	resp := s.handle(body)
+	status := http.StatusOK
+	switch {
+	case resp.Error == nil:
+	case resp.Error.Code == rpc.CodeMethodNotFound:
+		status = http.StatusNotFound
+	default:
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, resp)
+}
+
+func (s *Server) handle(raw json.RawMessage) response {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return parseError(err)
+	}
+	resp := response{ID: req.ID}
+	h, ok := handlers[req.Method]
+	if !ok {
+		resp.Error = &rpc.RPCError{Code: rpc.CodeMethodNotFound, Message: "Method not found"}
+		return resp
+	}
+
+	s.Chain.mu.Lock()
+	result, err := h(s.Chain, req.Params)
+	s.Chain.mu.Unlock()
+	if err != nil {
+		rpcErr, ok := err.(*rpc.RPCError)
+		if !ok {
+			rpcErr = &rpc.RPCError{Code: rpc.CodeMiscError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+		return resp
+	}
+	resp.Result = result
+	return resp
+}
+
+func parseError(err error) response {
+	return response{Error: &rpc.RPCError{Code: rpc.CodeParseError, Message: "Parse error: " + err.Error()}}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// args are the positional parameters of a request.
+type args []json.RawMessage
+
+// get decodes parameter i into v and reports whether it was given.
+func (a args) get(i int, v interface{}) (bool, error) {
+	if i >= len(a) || string(a[i]) == "null" {
+		return false, nil
+	}
+	if err := json.Unmarshal(a[i], v); err != nil {
+		return false, &rpc.RPCError{Code: rpc.CodeTypeError, Message: fmt.Sprintf("invalid parameter %d: %v", i+1, err)}
+	}
+	return true, nil
+}
+
+// require is get for mandatory parameters.
+func (a args) require(i int, v interface{}) error {
+	ok, err := a.get(i, v)
+	if err == nil && !ok {
+		err = &rpc.RPCError{Code: rpc.CodeMiscError, Message: fmt.Sprintf("missing parameter %d", i+1)}
+	}
+	return err
+}
+
+// flag decodes parameter i as a boolean, accepting numbers like bitcoind
+// does for the verbose arguments.
+func (a args) flag(i int, def bool) (bool, error) {
+	var v interface{}
+	ok, err := a.get(i, &v)
+	if err != nil || !ok {
+		return def, err
+	}
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case float64:
+		return v != 0, nil
+	}
+	return false, &rpc.RPCError{Code: rpc.CodeTypeError, Message: fmt.Sprintf("invalid parameter %d: expected bool", i+1)}
+}
@@ -0,0 +1,125 @@
+package rpctest
+
+import (
+	"bytes"
+	"fmt"
+
+	"bitcoin-playground/btc"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TxIn spends an output of a previous transaction. Coinbase inputs have an
+// empty PrevTxID.
+type TxIn struct {
+	PrevTxID  string
+	PrevVout  uint32
+	ScriptSig []byte
+	Witness   [][]byte
+	Sequence  uint32
+}
+
+// TxOut is a transaction output.
+type TxOut struct {
+	Value  btc.Amount
+	Script []byte
+}
+
+// Tx is a bitcoin transaction in the wire format used by bitcoind.
+type Tx struct {
+	Version  int32
+	Inputs   []TxIn
+	Outputs  []TxOut
+	LockTime uint32
+}
+
+// IsCoinbase reports whether tx creates new coins.
+func (tx *Tx) IsCoinbase() bool {
+	return len(tx.Inputs) == 1 && tx.Inputs[0].PrevTxID == ""
+}
+
+// msgTx converts tx to its btcd form. Previous txids that are not hashes
+// are encoded as the null hash.
+func (tx *Tx) msgTx() *wire.MsgTx {
+	msg := wire.NewMsgTx(tx.Version)
+	for _, in := range tx.Inputs {
+		prev := wire.OutPoint{Index: wire.MaxPrevOutIndex}
+		if in.PrevTxID != "" {
+			prev.Index = in.PrevVout
+			if h, err := chainhash.NewHashFromStr(in.PrevTxID); err == nil {
+				prev.Hash = *h
+			}
+		}
+		txIn := wire.NewTxIn(&prev, in.ScriptSig, in.Witness)
+		txIn.Sequence = in.Sequence
+		msg.AddTxIn(txIn)
+	}
+	for _, out := range tx.Outputs {
+		msg.AddTxOut(wire.NewTxOut(int64(out.Value), out.Script))
+	}
+	msg.LockTime = tx.LockTime
+	return msg
+}
+
+// fromMsgTx is the inverse of msgTx.
+func fromMsgTx(msg *wire.MsgTx) *Tx {
+	tx := &Tx{Version: msg.Version, LockTime: msg.LockTime}
+	for _, in := range msg.TxIn {
+		txIn := TxIn{ScriptSig: in.SignatureScript, Witness: in.Witness, Sequence: in.Sequence}
+		if prev := in.PreviousOutPoint; prev.Index != wire.MaxPrevOutIndex || prev.Hash != (chainhash.Hash{}) {
+			txIn.PrevTxID, txIn.PrevVout = prev.Hash.String(), prev.Index
+		}
+		tx.Inputs = append(tx.Inputs, txIn)
+	}
+	for _, out := range msg.TxOut {
+		tx.Outputs = append(tx.Outputs, TxOut{Value: btc.Amount(out.Value), Script: out.PkScript})
+	}
+	return tx
+}
+
+// Serialize encodes tx, including the witness data when witness is set.
+func (tx *Tx) Serialize(witness bool) []byte {
+	var buf bytes.Buffer
+	msg := tx.msgTx()
+	if witness {
+		_ = msg.Serialize(&buf)
+	} else {
+		_ = msg.SerializeNoWitness(&buf)
+	}
+	return buf.Bytes()
+}
+
+// TxID returns the transaction id, the hash of tx without witness data.
+func (tx *Tx) TxID() string {
+	return tx.msgTx().TxHash().String()
+}
+
+// WTxID returns the hash of tx including witness data.
+func (tx *Tx) WTxID() string {
+	return tx.msgTx().WitnessHash().String()
+}
+
+// Weight returns the BIP141 weight of tx.
+func (tx *Tx) Weight() int64 {
+	msg := tx.msgTx()
+	return int64(msg.SerializeSizeStripped()*3 + msg.SerializeSize())
+}
+
+// VSize returns the virtual size of tx in vbytes.
+func (tx *Tx) VSize() int64 {
+	return (tx.Weight() + 3) / 4
+}
+
+// ParseTx decodes a serialized transaction, with or without witness data.
+func ParseTx(b []byte) (*Tx, error) {
+	r := bytes.NewReader(b)
+	var msg wire.MsgTx
+	if err := msg.Deserialize(r); err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes", r.Len())
+	}
+	return fromMsgTx(&msg), nil
+}
@@ -0,0 +1,174 @@
+package rpctest
+
+import (
+	"bytes"
+	"testing"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/network"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// sampleTx has a legacy and a witness input, and a script long enough for a
+// three-byte length prefix.
+func sampleTx() *Tx {
+	return &Tx{
+		Version: 2,
+		Inputs: []TxIn{
+			{PrevTxID: "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b", PrevVout: 1, ScriptSig: []byte{0x51}, Sequence: 0xfffffffd},
+			{PrevTxID: "0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098", PrevVout: 0, ScriptSig: []byte{}, Witness: [][]byte{{0x30, 0x44}, bytes.Repeat([]byte{0x02}, 33)}, Sequence: 0xffffffff},
+		},
+		Outputs: []TxOut{
+			{Value: 12_345, Script: []byte{txscript.OP_0, 20, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}},
+			{Value: 0, Script: append([]byte{txscript.OP_RETURN}, bytes.Repeat([]byte{0xab}, 300)...)},
+		},
+		LockTime: 840_000,
+	}
+}
+
+func TestTxRoundTrip(t *testing.T) {
+	tx := sampleTx()
+	for _, witness := range []bool{true, false} {
+		b := tx.Serialize(witness)
+		parsed, err := ParseTx(b)
+		if err != nil {
+			t.Fatalf("ParseTx (witness %v): %v", witness, err)
+		}
+		if !bytes.Equal(parsed.Serialize(witness), b) {
+			t.Errorf("witness %v: round trip changed the encoding", witness)
+		}
+		if parsed.TxID() != tx.TxID() {
+			t.Errorf("witness %v: txid %s, want %s", witness, parsed.TxID(), tx.TxID())
+		}
+	}
+	if tx.TxID() == tx.WTxID() {
+		t.Error("txid and wtxid of a witness transaction are equal")
+	}
+	if len(tx.Serialize(true)) <= len(tx.Serialize(false)) {
+		t.Error("witness serialization is not longer")
+	}
+}
+
+func TestTxMatchesWire(t *testing.T) {
+	tx := sampleTx()
+	var msg wire.MsgTx
+	if err := msg.Deserialize(bytes.NewReader(tx.Serialize(true))); err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.TxHash().String(); got != tx.TxID() {
+		t.Errorf("txid %s, btcd computes %s", tx.TxID(), got)
+	}
+	if got := msg.WitnessHash().String(); got != tx.WTxID() {
+		t.Errorf("wtxid %s, btcd computes %s", tx.WTxID(), got)
+	}
+	if got := int64(msg.SerializeSizeStripped()*3 + msg.SerializeSize()); got != tx.Weight() {
+		t.Errorf("weight %d, btcd computes %d", tx.Weight(), got)
+	}
+	if msg.TxIn[0].PreviousOutPoint.Hash.String() != tx.Inputs[0].PrevTxID || msg.TxIn[1].Sequence != 0xffffffff {
+		t.Errorf("btcd decodes the inputs as %v", msg.TxIn)
+	}
+	if msg.LockTime != tx.LockTime || len(msg.TxOut[1].PkScript) != 301 {
+		t.Errorf("btcd decodes locktime %d and an OP_RETURN script of %d bytes", msg.LockTime, len(msg.TxOut[1].PkScript))
+	}
+
+	// And the other way around.
+	var buf bytes.Buffer
+	if err := msg.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseTx(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.WTxID() != tx.WTxID() {
+		t.Errorf("parsed btcd encoding has wtxid %s, want %s", parsed.WTxID(), tx.WTxID())
+	}
+}
+
+func TestParseCoinbase(t *testing.T) {
+	coinbase := &Tx{
+		Version: 1,
+		Inputs:  []TxIn{{ScriptSig: []byte{4, 1, 0, 0, 0}, Sequence: 0xffffffff}},
+		Outputs: []TxOut{{Value: Subsidy, Script: faucetScript}},
+	}
+	parsed, err := ParseTx(coinbase.Serialize(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.IsCoinbase() {
+		t.Error("parsed coinbase is not a coinbase")
+	}
+	if sampleTx().IsCoinbase() {
+		t.Error("sample transaction is a coinbase")
+	}
+}
+
+func TestParseTxRejects(t *testing.T) {
+	b := sampleTx().Serialize(true)
+	tests := map[string][]byte{
+		"empty":          nil,
+		"truncated":      b[:len(b)-1],
+		"trailing bytes": append(append([]byte{}, b...), 0),
+		"segwit flag":    append(append(append([]byte{}, b[:5]...), 0x02), b[6:]...),
+		"input count":    {2, 0, 0, 0, 0xfd, 0xff, 0xff},
+	}
+	for name, data := range tests {
+		if _, err := ParseTx(data); err == nil {
+			t.Errorf("%s: ParseTx succeeded", name)
+		}
+	}
+}
+
+func TestBlockMatchesWire(t *testing.T) {
+	chain := NewChain(&network.Regtest)
+	for _, amount := range []btc.Amount{10_000, 20_000} {
+		if _, err := chain.Fund("bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080", amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := chain.Mine(1)[0]
+	if len(b.Txs) != 3 {
+		t.Fatalf("block has %d transactions, want 3", len(b.Txs))
+	}
+
+	var msg wire.MsgBlock
+	if err := msg.Deserialize(bytes.NewReader(b.Serialize())); err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.BlockHash().String(); got != b.Hash() {
+		t.Errorf("block hash %s, btcd computes %s", b.Hash(), got)
+	}
+	if got := msg.Header.PrevBlock.String(); got != b.PrevHash {
+		t.Errorf("previous block %s, want %s", got, b.PrevHash)
+	}
+	if msg.Header.Bits != regtestBits || msg.Header.Timestamp.Unix() != b.Time {
+		t.Errorf("header bits %x and time %d, want %x and %d", msg.Header.Bits, msg.Header.Timestamp.Unix(), regtestBits, b.Time)
+	}
+	for i, tx := range msg.Transactions {
+		if got := tx.TxHash().String(); got != b.Txs[i].TxID() {
+			t.Errorf("tx %d: btcd reads %s, want %s", i, got, b.Txs[i].TxID())
+		}
+	}
+
+	// Three transactions: the last one is paired with itself.
+	ids := make([]chainhash.Hash, 3)
+	for i, tx := range msg.Transactions {
+		ids[i] = tx.TxHash()
+	}
+	left := chainhash.DoubleHashB(append(ids[0][:], ids[1][:]...))
+	right := chainhash.DoubleHashB(append(ids[2][:], ids[2][:]...))
+	root := chainhash.DoubleHashH(append(left, right...)).String()
+	if b.MerkleRoot() != root || msg.Header.MerkleRoot.String() != root {
+		t.Errorf("merkle root %s (header %s), want %s", b.MerkleRoot(), msg.Header.MerkleRoot, root)
+	}
+}
+
+func TestBlockSingleTxMerkleRoot(t *testing.T) {
+	b := NewChain(&network.Regtest).Mine(1)[0]
+	if b.MerkleRoot() != b.Txs[0].TxID() {
+		t.Errorf("merkle root %s of a single transaction, want its txid %s", b.MerkleRoot(), b.Txs[0].TxID())
+	}
+}
@@ -0,0 +1,236 @@
+package rpctest
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"bitcoin-playground/btc"
+	"bitcoin-playground/rpc"
+)
+
+// walletInfo is the result of createwallet.
+type walletInfo struct {
+	Name    string `json:"name"`
+	Warning string `json:"warning"`
+}
+
+// createWallet only renames the single wallet of the fake node.
+func createWallet(c *Chain, a args) (interface{}, error) {
+	var name string
+	if err := a.require(0, &name); err != nil {
+		return nil, err
+	}
+	c.wallet.name = name
+	return walletInfo{Name: name}, nil
+}
+
+func walletPassphrase(c *Chain, a args) (interface{}, error) {
+	var passphrase string
+	var timeout int64
+	if err := a.require(0, &passphrase); err != nil {
+		return nil, err
+	}
+	if err := a.require(1, &timeout); err != nil {
+		return nil, err
+	}
+	if c.wallet.passphrase == "" {
+		return nil, &rpc.RPCError{Code: rpc.CodeWalletWrongEncState, Message: "Error: running with an unencrypted wallet, but walletpassphrase was called."}
+	}
+	if passphrase != c.wallet.passphrase {
+		return nil, &rpc.RPCError{Code: rpc.CodeWalletPassphraseWrong, Message: "Error: The wallet passphrase entered was incorrect."}
+	}
+	c.wallet.unlockedUntil = time.Now().Add(time.Duration(timeout) * time.Second)
+	return nil, nil
+}
+
+func getNewAddress(c *Chain, a args) (interface{}, error) {
+	var account string
+	if _, err := a.get(0, &account); err != nil {
+		return nil, err
+	}
+	return c.newAddress(account), nil
+}
+
+func getAddressesByAccount(c *Chain, a args) (interface{}, error) {
+	var account string
+	if _, err := a.get(0, &account); err != nil {
+		return nil, err
+	}
+	addresses := []string{}
+	for addr, acct := range c.wallet.accounts {
+		if acct == account {
+			addresses = append(addresses, addr)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+func getBalance(c *Chain, a args) (interface{}, error) {
+	account, minConf := "*", int64(1)
+	if _, err := a.get(0, &account); err != nil {
+		return nil, err
+	}
+	if _, err := a.get(1, &minConf); err != nil {
+		return nil, err
+	}
+	var balance btc.Amount
+	for _, coin := range c.walletCoins() {
+		addr, _ := c.mine(coin.out.Script)
+		if c.coinConfirmations(coin.height) < minConf {
+			continue
+		}
+		if account == "*" || c.wallet.accounts[addr] == account {
+			balance += coin.out.Value
+		}
+	}
+	return balance, nil
+}
+
+func listUnspent(c *Chain, a args) (interface{}, error) {
+	minConf, maxConf := int64(1), int64(9999999)
+	var addresses []string
+	if _, err := a.get(0, &minConf); err != nil {
+		return nil, err
+	}
+	if _, err := a.get(1, &maxConf); err != nil {
+		return nil, err
+	}
+	if _, err := a.get(2, &addresses); err != nil {
+		return nil, err
+	}
+	filter := make(map[string]bool)
+	for _, addr := range addresses {
+		if _, err := AddressScript(c.params, addr); err != nil {
+			return nil, &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: "Invalid Bitcoin address: " + addr}
+		}
+		filter[addr] = true
+	}
+
+	coins := c.walletCoins()
+	unspent := []rpc.Unspent{}
+	for _, op := range sortedOutpoints(coins) {
+		coin := coins[op]
+		addr, _ := c.mine(coin.out.Script)
+		conf := c.coinConfirmations(coin.height)
+		if conf < minConf || conf > maxConf || (len(filter) > 0 && !filter[addr]) {
+			continue
+		}
+		unspent = append(unspent, rpc.Unspent{
+			TxID:          op.txid,
+			Vout:          op.vout,
+			Address:       addr,
+			Account:       c.wallet.accounts[addr],
+			ScriptPubKey:  hex.EncodeToString(coin.out.Script),
+			Amount:        coin.out.Value,
+			Confirmations: conf,
+			Spendable:     true,
+		})
+	}
+	return unspent, nil
+}
+
+func sendToAddress(c *Chain, a args) (interface{}, error) {
+	var addr string
+	var amount btc.Amount
+	if err := a.require(0, &addr); err != nil {
+		return nil, err
+	}
+	if err := a.require(1, &amount); err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		return nil, &rpc.RPCError{Code: rpc.CodeTypeError, Message: "Invalid amount for send"}
+	}
+	return c.send(addr, amount)
+}
+
+func dumpPrivKey(c *Chain, a args) (interface{}, error) {
+	var addr string
+	if err := a.require(0, &addr); err != nil {
+		return nil, err
+	}
+	if _, err := AddressScript(c.params, addr); err != nil {
+		return nil, &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: "Invalid Bitcoin address"}
+	}
+	if c.locked() {
+		return nil, &rpc.RPCError{Code: rpc.CodeWalletUnlockNeeded, Message: "Error: Please enter the wallet passphrase with walletpassphrase first."}
+	}
+	key, ok := c.wallet.keys[addr]
+	if !ok {
+		return nil, &rpc.RPCError{Code: rpc.CodeWalletError, Message: fmt.Sprintf("Private key for address %s is not known", addr)}
+	}
+	return key, nil
+}
+
+func getTransaction(c *Chain, a args) (interface{}, error) {
+	var txid string
+	if err := a.require(0, &txid); err != nil {
+		return nil, err
+	}
+	tx, b, found := c.findTx(txid)
+	notFound := &rpc.RPCError{Code: rpc.CodeInvalidAddressOrKey, Message: "Invalid or non-wallet transaction id"}
+	if !found {
+		return nil, notFound
+	}
+
+	var debit btc.Amount
+	for _, in := range tx.Inputs {
+		if out, ok := c.prevOut(in); ok {
+			if _, mine := c.mine(out.Script); mine {
+				debit += out.Value
+			}
+		}
+	}
+	wtx := &rpc.WalletTransaction{
+		TxID:            txid,
+		WalletConflicts: []string{},
+		Hex:             hex.EncodeToString(tx.Serialize(true)),
+	}
+	for i, out := range tx.Outputs {
+		addr, mine := c.mine(out.Script)
+		switch {
+		case debit > 0 && !mine:
+			wtx.Amount -= out.Value
+			wtx.Details = append(wtx.Details, rpc.WalletTransactionDetail{
+				Address:  ScriptAddress(c.params, out.Script),
+				Category: "send",
+				Amount:   -out.Value,
+				Vout:     uint32(i),
+				Fee:      -c.fee(tx),
+			})
+		case debit == 0 && mine:
+			wtx.Amount += out.Value
+			wtx.Details = append(wtx.Details, rpc.WalletTransactionDetail{
+				Account:  c.wallet.accounts[addr],
+				Address:  addr,
+				Category: "receive",
+				Amount:   out.Value,
+				Vout:     uint32(i),
+			})
+		}
+	}
+	if debit == 0 && len(wtx.Details) == 0 {
+		return nil, notFound
+	}
+	if debit > 0 {
+		wtx.Fee = -c.fee(tx)
+	}
+
+	tip := c.blocks[len(c.blocks)-1]
+	wtx.Time, wtx.TimeReceived = tip.Time, tip.Time
+	if b != nil {
+		wtx.Confirmations = c.confirmations(b)
+		wtx.BlockHash = b.Hash()
+		wtx.BlockTime = b.Time
+		wtx.Time, wtx.TimeReceived = b.Time, b.Time
+		for i, t := range b.Txs {
+			if t == tx {
+				wtx.BlockIndex = int64(i)
+			}
+		}
+	}
+	return wtx, nil
+}
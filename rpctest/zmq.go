@@ -0,0 +1,53 @@
+package rpctest
+
+import (
+	"encoding/hex"
+
+	"bitcoin-playground/zmq"
+)
+
+// ZMQPublisher publishes the hashblock, rawblock, hashtx and rawtx
+// notifications of a Chain, like bitcoind started with the -zmqpub* options
+// all pointing at Endpoint.
+type ZMQPublisher struct {
+	*zmq.Publisher
+}
+
+// NewZMQPublisher starts publishing the changes of chain on endpoint,
+// e.g. "tcp://127.0.0.1:0".
+func NewZMQPublisher(chain *Chain, endpoint string) (*ZMQPublisher, error) {
+	pub, err := zmq.Listen(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	p := &ZMQPublisher{Publisher: pub}
+	chain.AddListener(p)
+	return p, nil
+}
+
+// BlockConnected publishes the transactions of b, then b itself, in the order
+// bitcoind does.
+func (p *ZMQPublisher) BlockConnected(b *Block) {
+	for _, tx := range b.Txs {
+		p.publishTx(tx)
+	}
+	p.Publish("hashblock", hashBytes(b.Hash()))
+	p.Publish("rawblock", b.Serialize())
+}
+
+// TxAccepted publishes a transaction entering the mempool.
+func (p *ZMQPublisher) TxAccepted(tx *Tx) {
+	p.publishTx(tx)
+}
+
+func (p *ZMQPublisher) publishTx(tx *Tx) {
+	p.Publish("hashtx", hashBytes(tx.TxID()))
+	p.Publish("rawtx", tx.Serialize(true))
+}
+
+// hashBytes decodes a hash in the byte order it is displayed in, which is
+// also the order bitcoind publishes it in.
+func hashBytes(hash string) []byte {
+	b, _ := hex.DecodeString(hash)
+	return b
+}
@@ -0,0 +1,177 @@
+package zmq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// queueSize is how many messages a slow subscriber may fall behind before
+// further ones are dropped, like a ZeroMQ high-water mark.
+const queueSize = 1000
+
+// Publisher is a PUB socket that publishes notifications the way bitcoind
+// does: topic, body and a little-endian sequence number per topic.
+type Publisher struct {
+	// Endpoint is the address to subscribe to, e.g. tcp://127.0.0.1:40123.
+	Endpoint string
+
+	ln   net.Listener
+	mu   sync.Mutex
+	subs map[*peer]bool
+	seq  map[string]uint32
+}
+
+// peer is a connected subscriber.
+type peer struct {
+	conn   net.Conn
+	mu     sync.Mutex
+	topics [][]byte
+	queue  chan [][]byte
+}
+
+// Listen starts publishing on endpoint (tcp://host:port); port 0 picks a free one.
+func Listen(endpoint string) (*Publisher, error) {
+	addr, err := tcpAddress(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Publisher{
+		Endpoint: "tcp://" + ln.Addr().String(),
+		ln:       ln,
+		subs:     make(map[*peer]bool),
+		seq:      make(map[string]uint32),
+	}
+	go p.accept()
+	return p, nil
+}
+
+// Publish sends body under topic to every subscriber of the topic. Like
+// ZeroMQ, it never blocks: subscribers that are not connected yet or too far
+// behind miss the message.
+func (p *Publisher) Publish(topic string, body []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seq := binary.LittleEndian.AppendUint32(nil, p.seq[topic])
+	p.seq[topic]++
+	msg := [][]byte{[]byte(topic), body, seq}
+	for s := range p.subs {
+		if !s.subscribed(topic) {
+			continue
+		}
+		select {
+		case s.queue <- msg:
+		default:
+		}
+	}
+}
+
+// Close stops listening and disconnects the subscribers.
+func (p *Publisher) Close() error {
+	err := p.ln.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for s := range p.subs {
+		s.conn.Close()
+	}
+	return err
+}
+
+func (p *Publisher) accept() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.serve(conn)
+	}
+}
+
+// serve handshakes with a subscriber, then tracks its subscriptions while
+// writing its queued messages.
+func (p *Publisher) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if err := handshake(conn, r, "PUB", "SUB"); err != nil {
+		return
+	}
+	s := &peer{conn: conn, queue: make(chan [][]byte, queueSize)}
+	p.mu.Lock()
+	p.subs[s] = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.subs, s)
+		p.mu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			f, err := readFrame(r)
+			if err != nil {
+				return
+			}
+			s.update(f)
+		}
+	}()
+	for {
+		select {
+		case msg := <-s.queue:
+			if err := writeMessage(conn, msg); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// update applies a subscription frame: a message starting with 1 (subscribe)
+// or 0 (unsubscribe), or the SUBSCRIBE and CANCEL commands of ZMTP 3.1.
+func (s *peer) update(f frame) {
+	var add bool
+	var topic []byte
+	switch {
+	case f.command():
+		name, props, err := parseCommand(f)
+		if err != nil || (name != "SUBSCRIBE" && name != "CANCEL") {
+			return
+		}
+		add, topic = name == "SUBSCRIBE", []byte(props[""])
+	case len(f.body) > 0 && f.body[0] <= 1:
+		add, topic = f.body[0] == 1, f.body[1:]
+	default:
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		s.topics = append(s.topics, topic)
+		return
+	}
+	for i, t := range s.topics {
+		if bytes.Equal(t, topic) {
+			s.topics = append(s.topics[:i], s.topics[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *peer) subscribed(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.topics {
+		if bytes.HasPrefix([]byte(topic), t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package zmq
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Notification is a message as bitcoind publishes it.
+type Notification struct {
+	Topic string // e.g. "hashblock", "rawblock", "rawtx"
+	Body  []byte
+	// Sequence counts the messages of the topic; a gap means some were lost.
+	Sequence uint32
+}
+
+// Subscriber is a SUB socket connected to a single publisher.
+type Subscriber struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Dial connects to the PUB socket at endpoint (tcp://host:port) and
+// subscribes to topics, matched as prefixes as ZeroMQ does.
+func Dial(ctx context.Context, endpoint string, topics ...string) (*Subscriber, error) {
+	addr, err := tcpAddress(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscriber{conn: conn, r: bufio.NewReader(conn)}
+	if err := handshake(conn, s.r, "SUB", "PUB"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("zmq handshake with %s: %w", endpoint, err)
+	}
+	// ZMTP 3.0 subscriptions are messages made of 0x01 and the topic.
+	for _, topic := range topics {
+		if err := writeMessage(conn, [][]byte{append([]byte{1}, topic...)}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Receive waits for the next notification.
+func (s *Subscriber) Receive() (Notification, error) {
+	parts, err := readMessage(s.r)
+	if err != nil {
+		return Notification{}, err
+	}
+	n := Notification{Topic: string(parts[0])}
+	if len(parts) > 1 {
+		n.Body = parts[1]
+	}
+	if len(parts) > 2 && len(parts[2]) == 4 {
+		n.Sequence = binary.LittleEndian.Uint32(parts[2])
+	}
+	return n, nil
+}
+
+// Close disconnects; a pending Receive returns an error.
+func (s *Subscriber) Close() error {
+	return s.conn.Close()
+}
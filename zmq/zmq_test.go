@@ -0,0 +1,328 @@
+package zmq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen starts a publisher on a free local port.
+func listen(t *testing.T) *Publisher {
+	t.Helper()
+	pub, err := Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pub.Close() })
+	return pub
+}
+
+// dial subscribes to topics of pub and returns the notifications received.
+func dial(t *testing.T, pub *Publisher, topics ...string) <-chan Notification {
+	t.Helper()
+	sub, err := Dial(context.Background(), pub.Endpoint, topics...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Close() })
+	received := make(chan Notification, queueSize)
+	go func() {
+		defer close(received)
+		for {
+			n, err := sub.Receive()
+			if err != nil {
+				return
+			}
+			received <- n
+		}
+	}()
+	return received
+}
+
+// waitSubscribed publishes "sync" to topic until a message of it arrives,
+// as subscriptions take effect asynchronously. Messages that arrive
+// meanwhile are dropped.
+func waitSubscribed(t *testing.T, pub *Publisher, received <-chan Notification, topic string) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		pub.Publish(topic, []byte("sync"))
+		select {
+		case n := <-received:
+			if n.Topic == topic {
+				// Drain the other sync messages in flight.
+				for {
+					select {
+					case <-received:
+					case <-time.After(50 * time.Millisecond):
+						return
+					}
+				}
+			}
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("no %s message arrived", topic)
+		}
+	}
+}
+
+// next returns the next notification other than those of waitSubscribed,
+// failing after a timeout.
+func next(t *testing.T, received <-chan Notification) Notification {
+	t.Helper()
+	for {
+		select {
+		case n, ok := <-received:
+			if !ok {
+				t.Fatal("subscription closed")
+			}
+			if string(n.Body) != "sync" {
+				return n
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message arrived")
+		}
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	pub := listen(t)
+	received := dial(t, pub, "hashblock", "rawtx")
+	waitSubscribed(t, pub, received, "hashblock")
+	waitSubscribed(t, pub, received, "rawtx")
+
+	// A raw transaction over 255 bytes takes a long frame.
+	rawTx := bytes.Repeat([]byte{0xab}, 300)
+	hash := bytes.Repeat([]byte{0x01}, 32)
+	pub.Publish("hashtx", hash)
+	pub.Publish("rawtx", rawTx)
+	pub.Publish("rawblock", bytes.Repeat([]byte{0xcd}, 1000))
+	pub.Publish("hashblock", hash)
+	pub.Publish("rawtx", nil)
+
+	tx, block, empty := next(t, received), next(t, received), next(t, received)
+	if tx.Topic != "rawtx" || !bytes.Equal(tx.Body, rawTx) {
+		t.Errorf("got %s of %d bytes, want rawtx of %d bytes", tx.Topic, len(tx.Body), len(rawTx))
+	}
+	if block.Topic != "hashblock" || !bytes.Equal(block.Body, hash) {
+		t.Errorf("got %s %x, want hashblock %x", block.Topic, block.Body, hash)
+	}
+	if empty.Topic != "rawtx" || len(empty.Body) != 0 {
+		t.Errorf("got %s of %d bytes, want an empty rawtx", empty.Topic, len(empty.Body))
+	}
+	// Sequences count every message of the topic, including the ones
+	// published before subscribing.
+	if empty.Sequence != tx.Sequence+1 {
+		t.Errorf("rawtx sequences %d then %d, want consecutive ones", tx.Sequence, empty.Sequence)
+	}
+	select {
+	case n := <-received:
+		t.Errorf("received %s, which is not subscribed to", n.Topic)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscribePrefix(t *testing.T) {
+	pub := listen(t)
+	received := dial(t, pub, "raw")
+	waitSubscribed(t, pub, received, "rawtx")
+
+	pub.Publish("hashblock", nil)
+	pub.Publish("rawblock", []byte{1})
+	if n := next(t, received); n.Topic != "rawblock" {
+		t.Errorf("got %s, want rawblock", n.Topic)
+	}
+}
+
+func TestPublishFansOut(t *testing.T) {
+	pub := listen(t)
+	first, second := dial(t, pub, "hashblock"), dial(t, pub, "hashblock")
+	waitSubscribed(t, pub, first, "hashblock")
+	waitSubscribed(t, pub, second, "hashblock")
+
+	pub.Publish("hashblock", []byte{7})
+	for _, received := range []<-chan Notification{first, second} {
+		if n := next(t, received); n.Topic != "hashblock" || !bytes.Equal(n.Body, []byte{7}) {
+			t.Errorf("got %s %x, want hashblock 07", n.Topic, n.Body)
+		}
+	}
+}
+
+func TestCloseEndsReceive(t *testing.T) {
+	pub := listen(t)
+	received := dial(t, pub, "hashblock")
+	waitSubscribed(t, pub, received, "hashblock")
+	pub.Close()
+	select {
+	case _, ok := <-received:
+		if ok {
+			t.Error("received a message after the publisher closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Receive did not return after the publisher closed")
+	}
+}
+
+// fakePeer accepts one connection and answers it with reply.
+func fakePeer(t *testing.T, reply []byte) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write(reply)
+		_, _ = io.Copy(io.Discard, conn) // Until the client gives up.
+	}()
+	return "tcp://" + ln.Addr().String()
+}
+
+func TestDialHandshakeErrors(t *testing.T) {
+	badVersion := greeting()
+	badVersion[10] = 2
+	curve := greeting()
+	copy(curve[12:32], "CURVE")
+	reqSocket := appendFrame(greeting(), flagCommand, readyCommand("REQ"))
+
+	tests := []struct {
+		name  string
+		reply []byte
+		want  string
+	}{
+		{"not ZMTP", []byte(strings.Repeat("HTTP/1.1 400 Bad Request\r\n", 4)), "not a ZMTP socket"},
+		{"ZMTP 2", badVersion, "unsupported ZMTP version"},
+		{"CURVE security", curve, "unsupported security mechanism"},
+		{"REQ socket", reqSocket, "peer is a REQ socket"},
+		{"no READY", appendFrame(greeting(), flagCommand, append([]byte{5}, "ERROR"...)), "expected READY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Dial(context.Background(), fakePeer(t, tt.reply), "rawtx")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Dial error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	for _, endpoint := range []string{"ipc:///tmp/bitcoind.sock", "127.0.0.1:28332", "tcp://"} {
+		if _, err := Dial(context.Background(), endpoint); err == nil {
+			t.Errorf("Dial(%q) succeeded", endpoint)
+		}
+		if _, err := Listen(endpoint); err == nil {
+			t.Errorf("Listen(%q) succeeded", endpoint)
+		}
+	}
+}
+
+func TestFrames(t *testing.T) {
+	var buf []byte
+	sizes := []int{0, 1, 255, 256, 70_000}
+	for i, size := range sizes {
+		var flags byte
+		if i < len(sizes)-1 {
+			flags = flagMore
+		}
+		buf = appendFrame(buf, flags, bytes.Repeat([]byte{byte(i)}, size))
+	}
+	// A command between the parts of a message is skipped.
+	buf = append(appendFrame(nil, flagCommand, append([]byte{4}, "PING"...)), buf...)
+
+	r := bufio.NewReader(bytes.NewReader(buf))
+	parts, err := readMessage(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != len(sizes) {
+		t.Fatalf("read %d parts, want %d", len(parts), len(sizes))
+	}
+	for i, part := range parts {
+		if !bytes.Equal(part, bytes.Repeat([]byte{byte(i)}, sizes[i])) {
+			t.Errorf("part %d has %d bytes, want %d bytes of %d", i, len(part), sizes[i], i)
+		}
+	}
+	if _, err := readMessage(r); err == nil {
+		t.Error("read a message past the end")
+	}
+
+	if f := appendFrame(nil, 0, make([]byte, 255)); f[0] != 0 || f[1] != 255 {
+		t.Errorf("255-byte frame starts %x, want a short frame", f[:2])
+	}
+	if f := appendFrame(nil, flagMore, make([]byte, 256)); f[0] != flagMore|flagLong || !bytes.Equal(f[1:9], []byte{0, 0, 0, 0, 0, 0, 1, 0}) {
+		t.Errorf("256-byte frame starts %x, want a long frame", f[:9])
+	}
+
+	huge := []byte{flagLong, 0, 0, 0, 0, 0x10, 0, 0, 0}
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(huge))); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("reading a 256 MB frame: error %v", err)
+	}
+	truncated := appendFrame(nil, 0, []byte("body"))[:4]
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(truncated))); err == nil {
+		t.Error("read a truncated frame")
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	name, props, err := parseCommand(frame{flags: flagCommand, body: readyCommand("PUB")})
+	if err != nil || name != "READY" || props["Socket-Type"] != "PUB" {
+		t.Errorf("parseCommand(READY) = %q, %v, %v", name, props, err)
+	}
+	name, props, err = parseCommand(frame{flags: flagCommand, body: append([]byte{9}, "SUBSCRIBE"+"rawtx"...)})
+	if err != nil || name != "SUBSCRIBE" || props[""] != "rawtx" {
+		t.Errorf("parseCommand(SUBSCRIBE) = %q, %v, %v", name, props, err)
+	}
+
+	ready := readyCommand("PUB")
+	for name, f := range map[string]frame{
+		"not a command":      {body: ready},
+		"empty":              {flags: flagCommand},
+		"name too long":      {flags: flagCommand, body: []byte{9, 'R'}},
+		"truncated property": {flags: flagCommand, body: ready[:len(ready)-1]},
+		"truncated key":      {flags: flagCommand, body: ready[:len("READY")+3]},
+	} {
+		if _, _, err := parseCommand(f); err == nil {
+			t.Errorf("%s: parseCommand succeeded", name)
+		}
+	}
+}
+
+func TestPeerSubscriptions(t *testing.T) {
+	var s peer
+	message := func(b byte, topic string) frame { return frame{body: append([]byte{b}, topic...)} }
+	command := func(name, topic string) frame {
+		return frame{flags: flagCommand, body: append(append([]byte{byte(len(name))}, name...), topic...)}
+	}
+
+	s.update(message(1, "hash"))
+	s.update(command("SUBSCRIBE", "rawtx"))
+	for topic, want := range map[string]bool{"hashblock": true, "hashtx": true, "rawtx": true, "rawblock": false} {
+		if got := s.subscribed(topic); got != want {
+			t.Errorf("subscribed(%q) = %v, want %v", topic, got, want)
+		}
+	}
+
+	s.update(message(0, "hash"))
+	s.update(command("CANCEL", "rawtx"))
+	s.update(frame{body: []byte{2, 'x'}}) // Neither subscribe nor unsubscribe.
+	for _, topic := range []string{"hashblock", "rawtx", "x"} {
+		if s.subscribed(topic) {
+			t.Errorf("still subscribed to %q", topic)
+		}
+	}
+
+	// An empty topic matches everything.
+	s.update(message(1, ""))
+	if !s.subscribed("sequence") {
+		t.Error("empty subscription does not match sequence")
+	}
+}
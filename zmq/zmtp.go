@@ -0,0 +1,211 @@
+// Package zmq implements just enough of ZeroMQ (ZMTP 3.0 PUB/SUB over TCP
+// with the NULL security mechanism) to receive the notifications bitcoind
+// publishes with -zmqpubhashblock, -zmqpubrawblock, -zmqpubrawtx and so on,
+// and a Publisher that stands in for bitcoind in tests.
+//
+//	sub, err := zmq.Dial(ctx, "tcp://127.0.0.1:28332", "hashblock", "rawtx")
+//	for {
+//		n, err := sub.Receive()
+//		// n.Topic, n.Body, n.Sequence
+//	}
+package zmq
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Frame flags.
+const (
+	flagMore    = 0x01
+	flagLong    = 0x02
+	flagCommand = 0x04
+)
+
+// maxFrameSize bounds the frames read; a raw block is at most 4 MB.
+const maxFrameSize = 32 << 20
+
+// frame is a ZMTP frame: a message part or a command.
+type frame struct {
+	flags byte
+	body  []byte
+}
+
+func (f frame) more() bool    { return f.flags&flagMore != 0 }
+func (f frame) command() bool { return f.flags&flagCommand != 0 }
+
+// greeting returns the ZMTP 3.0 greeting for the NULL mechanism.
+func greeting() []byte {
+	g := make([]byte, 64)
+	g[0], g[9] = 0xff, 0x7f // signature
+	g[10], g[11] = 3, 0     // version
+	copy(g[12:32], "NULL")  // mechanism; as-server and filler stay zero
+	return g
+}
+
+// handshake exchanges greetings and READY commands over rw, announcing
+// socketType, and checks that the peer is a peerType socket.
+func handshake(rw io.ReadWriter, r *bufio.Reader, socketType, peerType string) error {
+	if _, err := rw.Write(greeting()); err != nil {
+		return err
+	}
+	peer := make([]byte, 64)
+	if _, err := io.ReadFull(r, peer); err != nil {
+		return fmt.Errorf("read greeting: %w", err)
+	}
+	if peer[0] != 0xff || peer[9]&0x01 == 0 {
+		return errors.New("peer is not a ZMTP socket")
+	}
+	if peer[10] < 3 {
+		return fmt.Errorf("unsupported ZMTP version %d.%d", peer[10], peer[11])
+	}
+	if mech := strings.TrimRight(string(peer[12:32]), "\x00"); mech != "NULL" {
+		return fmt.Errorf("unsupported security mechanism %q", mech)
+	}
+
+	if err := writeFrame(rw, flagCommand, readyCommand(socketType)); err != nil {
+		return err
+	}
+	f, err := readFrame(r)
+	if err != nil {
+		return fmt.Errorf("read READY: %w", err)
+	}
+	name, props, err := parseCommand(f)
+	if err != nil {
+		return err
+	}
+	if name != "READY" {
+		return fmt.Errorf("expected READY, got %s", name)
+	}
+	if typ := props["Socket-Type"]; typ != peerType && typ != "X"+peerType {
+		return fmt.Errorf("peer is a %s socket, want %s", typ, peerType)
+	}
+	return nil
+}
+
+// readyCommand is the body of a READY command.
+func readyCommand(socketType string) []byte {
+	body := []byte{5}
+	body = append(body, "READY"...)
+	body = append(body, byte(len("Socket-Type")))
+	body = append(body, "Socket-Type"...)
+	body = binary.BigEndian.AppendUint32(body, uint32(len(socketType)))
+	return append(body, socketType...)
+}
+
+// parseCommand splits a command frame into its name and properties. Commands
+// other than READY carry plain data, which is returned as the "" property.
+func parseCommand(f frame) (string, map[string]string, error) {
+	if !f.command() || len(f.body) < 1 || len(f.body) < 1+int(f.body[0]) {
+		return "", nil, errors.New("malformed command")
+	}
+	name, data := string(f.body[1:1+f.body[0]]), f.body[1+f.body[0]:]
+	props := make(map[string]string)
+	if name != "READY" {
+		props[""] = string(data)
+		return name, props, nil
+	}
+	for len(data) > 0 {
+		n := int(data[0])
+		if len(data) < 1+n+4 {
+			return "", nil, errors.New("malformed READY property")
+		}
+		key := string(data[1 : 1+n])
+		size := int(binary.BigEndian.Uint32(data[1+n:]))
+		data = data[1+n+4:]
+		if len(data) < size {
+			return "", nil, errors.New("malformed READY property")
+		}
+		props[key] = string(data[:size])
+		data = data[size:]
+	}
+	return name, props, nil
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return frame{}, err
+	}
+	var size uint64
+	if flags&flagLong != 0 {
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return frame{}, err
+		}
+		size = binary.BigEndian.Uint64(b[:])
+	} else {
+		b, err := r.ReadByte()
+		if err != nil {
+			return frame{}, err
+		}
+		size = uint64(b)
+	}
+	if size > maxFrameSize {
+		return frame{}, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	f := frame{flags: flags, body: make([]byte, size)}
+	if _, err := io.ReadFull(r, f.body); err != nil {
+		return frame{}, err
+	}
+	return f, nil
+}
+
+// readMessage reads the parts of the next message, skipping commands.
+func readMessage(r *bufio.Reader) ([][]byte, error) {
+	var parts [][]byte
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		if f.command() {
+			continue
+		}
+		parts = append(parts, f.body)
+		if !f.more() {
+			return parts, nil
+		}
+	}
+}
+
+func writeFrame(w io.Writer, flags byte, body []byte) error {
+	_, err := w.Write(appendFrame(nil, flags, body))
+	return err
+}
+
+// writeMessage writes parts as one message.
+func writeMessage(w io.Writer, parts [][]byte) error {
+	var buf []byte
+	for i, part := range parts {
+		var flags byte
+		if i < len(parts)-1 {
+			flags = flagMore
+		}
+		buf = appendFrame(buf, flags, part)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendFrame(buf []byte, flags byte, body []byte) []byte {
+	if len(body) > 255 {
+		buf = binary.BigEndian.AppendUint64(append(buf, flags|flagLong), uint64(len(body)))
+	} else {
+		buf = append(buf, flags, byte(len(body)))
+	}
+	return append(buf, body...)
+}
+
+// tcpAddress returns the host:port of a tcp:// endpoint.
+func tcpAddress(endpoint string) (string, error) {
+	addr, ok := strings.CutPrefix(endpoint, "tcp://")
+	if !ok || addr == "" {
+		return "", fmt.Errorf("unsupported endpoint %q, only tcp://host:port is supported", endpoint)
+	}
+	return addr, nil
+}